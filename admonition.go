@@ -0,0 +1,98 @@
+package smu
+
+import (
+	"bytes"
+	"strings"
+)
+
+// DefaultAdmonitions are the admonition kinds, with their titles, that are
+// recognised when Options.Admonitions is nil.
+var DefaultAdmonitions = map[string]string{
+	"note":      "Note",
+	"tip":       "Tip",
+	"important": "Important",
+	"warning":   "Warning",
+	"caution":   "Caution",
+}
+
+/* admonition returns the kind and title of an admonition of the given kind
+ * with an optional custom title, or false if the kind is not recognised */
+func (r *Renderer) admonition(kind, title []byte) (string, string, bool) {
+	kinds := r.opts.Admonitions
+	if kinds == nil {
+		kinds = DefaultAdmonitions
+	}
+	k := strings.ToLower(string(kind))
+	t, ok := kinds[k]
+	if !ok {
+		return "", "", false
+	}
+	if title = bytes.TrimSpace(title); len(title) > 0 {
+		t = string(title)
+	}
+	return k, t, true
+}
+
+/* callout turns the blockquote n into an admonition if its content starts
+ * with a line "[!KIND]" and returns the content without that line */
+func (r *Renderer) callout(n *Node, text []byte) []byte {
+	eol := lineend(text, 0)
+	line := bytes.TrimSpace(text[:eol])
+	stop := bytes.IndexByte(line, ']')
+	if !bytes.HasPrefix(line, []byte("[!")) || stop == -1 {
+		return text
+	}
+	kind, title, ok := r.admonition(line[2:stop], line[stop+1:])
+	if !ok {
+		return text
+	}
+	n.Type = Admonition
+	n.Class = kind
+	n.Title = title
+	return text[eol:]
+}
+
+/* doadmonition handles containers from a line ":::kind [title]" up to a
+ * line of at least as many colons */
+func (r *Renderer) doadmonition(text []byte, newblock bool) int {
+	begin, end := 0, len(text)
+
+	if !newblock {
+		return 0
+	}
+	p := begin
+	for p < end && text[p] == ':' {
+		p++
+	}
+	l := p - begin
+	if l < 3 {
+		return 0
+	}
+	eol := lineend(text, p)
+	line := bytes.TrimSpace(text[p:eol])
+	i := 0
+	for i < len(line) && !isSpace(line[i]) {
+		i++
+	}
+	kind, title, ok := r.admonition(line[:i], line[i:])
+	if !ok {
+		return 0
+	}
+
+	/* No closing line means the rest of the file is in the container */
+	start, stop, next := eol, end, end
+	for q := start; q < end; q = lineend(text, q) {
+		fence := bytes.TrimSpace(text[q:lineend(text, q)])
+		if len(fence) >= l && len(bytes.Trim(fence, ":")) == 0 {
+			stop, next = q, lineend(text, q)
+			break
+		}
+	}
+
+	r.EndParagraph()
+	n := r.AddNode(NewNode(Admonition))
+	n.Class = kind
+	n.Title = title
+	r.ParseInto(n, text[start:stop], true)
+	return -(next - begin)
+}
@@ -0,0 +1,49 @@
+package smu
+
+import "testing"
+
+func TestAdmonitions(t *testing.T) {
+	tests := []struct{ text, want string }{
+		{"> [!NOTE]\n> Be *careful*.\n",
+			"<div class=\"admonition note\">\n<p class=\"admonition-title\">Note</p>\n<p>Be <em>careful</em>.</p>\n</div>\n"},
+		{"> [!warning] Custom title\n> text\n",
+			"<div class=\"admonition warning\">\n<p class=\"admonition-title\">Custom title</p>\n<p>text</p>\n</div>\n"},
+		{"> [!NOTE]\n", "<div class=\"admonition note\">\n<p class=\"admonition-title\">Note</p>\n</div>\n"},
+		{"> [!NOTE]\n> a\n\n> b\n",
+			"<div class=\"admonition note\">\n<p class=\"admonition-title\">Note</p>\n<p>a</p>\n</div>\n<blockquote><p>b</p>\n</blockquote>\n"},
+		{":::warning\ntext\n:::\n",
+			"<div class=\"admonition warning\">\n<p class=\"admonition-title\">Warning</p>\n<p>text</p>\n</div>\n"},
+		{":::tip Read <this>\n- a\n:::\nafter\n",
+			"<div class=\"admonition tip\">\n<p class=\"admonition-title\">Read &lt;this&gt;</p>\n<ul>\n<li>a</li>\n</ul>\n</div>\n<p>after</p>\n"},
+		{"::::note\n:::tip\ninner\n:::\n::::\n",
+			"<div class=\"admonition note\">\n<p class=\"admonition-title\">Note</p>\n<div class=\"admonition tip\">\n<p class=\"admonition-title\">Tip</p>\n<p>inner</p>\n</div>\n</div>\n"},
+		{":::note\nno close\n", "<div class=\"admonition note\">\n<p class=\"admonition-title\">Note</p>\n<p>no close</p>\n</div>\n"},
+
+		/* Unknown kinds and markers not at the start are text */
+		{"> [!UNKNOWN]\n> text\n", "<blockquote><p>[!UNKNOWN]\ntext</p>\n</blockquote>\n"},
+		{"> text\n> [!NOTE]\n", "<blockquote><p>text\n[!NOTE]</p>\n</blockquote>\n"},
+		{":::unknown\ntext\n:::\n", "<p>:::unknown\ntext\n:::</p>\n"},
+		{"::note\n", "<p>::note</p>\n"},
+	}
+	for _, tt := range tests {
+		if got := string(New(Options{}).Process([]byte(tt.text))); got != tt.want {
+			t.Errorf("%q:\ngot  %q\nwant %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestAdmonitionKinds(t *testing.T) {
+	r := New(Options{Admonitions: map[string]string{"danger": "Danger!", "note": ""}})
+	tests := []struct{ text, want string }{
+		{"> [!DANGER]\n> x\n", "<div class=\"admonition danger\">\n<p class=\"admonition-title\">Danger!</p>\n<p>x</p>\n</div>\n"},
+		{":::danger\nx\n:::\n", "<div class=\"admonition danger\">\n<p class=\"admonition-title\">Danger!</p>\n<p>x</p>\n</div>\n"},
+		{"> [!TIP]\n> x\n", "<blockquote><p>[!TIP]\nx</p>\n</blockquote>\n"},
+		{"> [!NOTE]\n> x\n", "<div class=\"admonition note\">\n<p>x</p>\n</div>\n"},
+		{"> [!NOTE] Title\n> x\n", "<div class=\"admonition note\">\n<p class=\"admonition-title\">Title</p>\n<p>x</p>\n</div>\n"},
+	}
+	for _, tt := range tests {
+		if got := string(r.Process([]byte(tt.text))); got != tt.want {
+			t.Errorf("%q:\ngot  %q\nwant %q", tt.text, got, tt.want)
+		}
+	}
+}
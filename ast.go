@@ -0,0 +1,193 @@
+package smu
+
+// NodeType identifies the kind of a Node.
+type NodeType int
+
+const (
+	Document NodeType = iota
+	Paragraph
+	Heading
+	List
+	ListItem
+	DefinitionList
+	DefinitionTerm
+	Definition
+	CodeBlock
+	MathBlock
+	Blockquote
+	Admonition
+	Table
+	TableRow
+	TableCell
+	HorizontalRule
+	HTMLBlock
+	Text
+	Link
+	Image
+	Emphasis
+	Strong
+	Code
+	Math
+	RawHTML
+	Comment
+	LineBreak
+	Strikethrough
+	Mark
+	Superscript
+	Subscript
+	Footnotes
+	Footnote
+	FootnoteRef
+	CustomBlock
+	CustomInline
+)
+
+var nodeTypeNames = []string{
+	Document:       "Document",
+	Paragraph:      "Paragraph",
+	Heading:        "Heading",
+	List:           "List",
+	ListItem:       "ListItem",
+	DefinitionList: "DefinitionList",
+	DefinitionTerm: "DefinitionTerm",
+	Definition:     "Definition",
+	CodeBlock:      "CodeBlock",
+	MathBlock:      "MathBlock",
+	Blockquote:     "Blockquote",
+	Admonition:     "Admonition",
+	Table:          "Table",
+	TableRow:       "TableRow",
+	TableCell:      "TableCell",
+	HorizontalRule: "HorizontalRule",
+	HTMLBlock:      "HTMLBlock",
+	Text:           "Text",
+	Link:           "Link",
+	Image:          "Image",
+	Emphasis:       "Emphasis",
+	Strong:         "Strong",
+	Code:           "Code",
+	Math:           "Math",
+	RawHTML:        "RawHTML",
+	Comment:        "Comment",
+	LineBreak:      "LineBreak",
+	Strikethrough:  "Strikethrough",
+	Mark:           "Mark",
+	Superscript:    "Superscript",
+	Subscript:      "Subscript",
+	Footnotes:      "Footnotes",
+	Footnote:       "Footnote",
+	FootnoteRef:    "FootnoteRef",
+	CustomBlock:    "CustomBlock",
+	CustomInline:   "CustomInline",
+}
+
+func (t NodeType) String() string {
+	if t >= 0 && int(t) < len(nodeTypeNames) {
+		return nodeTypeNames[t]
+	}
+	return "NodeType(?)"
+}
+
+// Align is the alignment of a table column.
+type Align int
+
+const (
+	AlignNone Align = iota
+	AlignLeft
+	AlignRight
+	AlignCenter
+)
+
+// Node is an element of the document tree returned by Parse. Only the
+// fields relevant to its Type are set.
+type Node struct {
+	Type     NodeType
+	Parent   *Node
+	Children []*Node
+
+	// Literal is the content of Text, Code, CodeBlock, Math, MathBlock,
+	// RawHTML, HTMLBlock and Comment nodes.
+	Literal []byte
+
+	Level   int    // Heading level, 1 to 6
+	ID      string // Heading id
+	Ordered bool   // List is numbered
+	Start   int    // List start number
+	Tight   bool   // List items are not separated by blank lines, see Options.CommonMark
+	Task    bool   // ListItem starts with a checkbox
+	Checked bool   // ListItem checkbox is checked
+	Info    string // CodeBlock info string
+	Fenced  bool   // CodeBlock was written with a code fence
+	Attrs   []Attr // CodeBlock attributes from the info string
+	Display bool   // Math was written between "$$"
+
+	Dest     string // Link and Image destination
+	Title    string // Link, Image and Admonition title
+	Autolink bool   // Link was written as <url> or <address>, or bare
+	Bare     bool   // Link is a bare URL or address, see Options.Autolink
+
+	Label string // Footnote and FootnoteRef label
+	Index int    // Footnote and FootnoteRef number, starting at 1
+	Refs  int    // references to a Footnote, or which of them a FootnoteRef is
+
+	Align  Align // TableCell alignment
+	Header bool  // TableRow and TableCell belong to the header row
+
+	Element string // HTML element of CustomBlock and CustomInline
+	Class   string // class attribute of CustomBlock and CustomInline, Admonition kind
+
+	Pos SourcePos // source range of blocks, see Options.SourcePos
+}
+
+// NewNode returns a detached node of type t.
+func NewNode(t NodeType) *Node {
+	return &Node{Type: t}
+}
+
+// AppendChild adds c as the last child of n.
+func (n *Node) AppendChild(c *Node) {
+	c.Parent = n
+	n.Children = append(n.Children, c)
+}
+
+// LastChild returns the last child of n or nil.
+func (n *Node) LastChild() *Node {
+	if len(n.Children) == 0 {
+		return nil
+	}
+	return n.Children[len(n.Children)-1]
+}
+
+// Attr is an HTML attribute.
+type Attr struct {
+	Key, Value string
+}
+
+// WalkStatus tells Walk how to continue after visiting a node.
+type WalkStatus int
+
+const (
+	GoToNext WalkStatus = iota
+	SkipChildren
+	Terminate
+)
+
+// Walk visits n and its descendants in document order. visit is called
+// with entering set before the children of a node and unset after them.
+func Walk(n *Node, visit func(n *Node, entering bool) WalkStatus) WalkStatus {
+	status := visit(n, true)
+	if status == Terminate {
+		return Terminate
+	}
+	if status != SkipChildren {
+		for _, c := range n.Children {
+			if Walk(c, visit) == Terminate {
+				return Terminate
+			}
+		}
+	}
+	if visit(n, false) == Terminate {
+		return Terminate
+	}
+	return GoToNext
+}
@@ -0,0 +1,193 @@
+package smu
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+)
+
+/* schemes of bare URLs, "www." links get http */
+var autolinkSchemes = []string{"https://", "http://", "ftp://"}
+
+const alnumBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+var (
+	mailBytes   = alnumBytes + ".+-_" /* of the local part of mail addresses */
+	domainBytes = alnumBytes + "_-." + highBytes()
+)
+
+/* highBytes returns the bytes of UTF-8 beyond ASCII */
+func highBytes() string {
+	b := make([]byte, 0, 256-utf8.RuneSelf)
+	for c := utf8.RuneSelf; c < 256; c++ {
+		b = append(b, byte(c))
+	}
+	return string(b)
+}
+
+/* doautolink links bare URLs and mail addresses in running text, as
+ * GitHub does. Links do not start within a word and are not nested. */
+func (r *Renderer) doautolink(text []byte, newblock bool) int {
+	if !r.opts.Autolink {
+		return 0
+	}
+	for n := r.cur; n != nil; n = n.Parent {
+		if n.Type == Link {
+			return 0
+		}
+	}
+	prev := r.prevbyte()
+
+	var dest string
+	l := 0
+	if prev == 0 || strings.IndexByte(" \t\n*_~(", prev) != -1 {
+		dest, l = r.autolinkurl(text)
+	}
+	if l == 0 && !isAlnum(prev) && strings.IndexByte(".+-_@", prev) == -1 {
+		if l = r.autolinkemail(text); l != 0 {
+			dest = mailto + string(text[:l])
+		}
+	}
+	if l == 0 {
+		return 0
+	}
+
+	n := r.AddNode(NewNode(Link))
+	n.Autolink = true
+	n.Bare = true
+	n.Dest = dest
+	label := NewNode(Text)
+	label.Literal = append([]byte(nil), text[:l]...)
+	n.AppendChild(label)
+	return l
+}
+
+/* prevbyte returns the byte of text in front of the current position, or 0
+ * if it does not follow text */
+func (r *Renderer) prevbyte() byte {
+	last := r.cur.LastChild()
+	if last == nil || last.Type != Text || len(last.Literal) == 0 {
+		return 0
+	}
+	return last.Literal[len(last.Literal)-1]
+}
+
+/* autolinkurl returns the destination and length of the URL starting with
+ * a scheme or "www." at the start of text, or a length of 0 */
+func (r *Renderer) autolinkurl(text []byte) (string, int) {
+	prefix := ""
+	start := 0
+	if hasprefixfold(text, "www.") {
+		prefix = "http://"
+	} else {
+		for _, s := range autolinkSchemes {
+			if hasprefixfold(text, s) {
+				start = len(s)
+				break
+			}
+		}
+		if start == 0 {
+			return "", 0
+		}
+	}
+	domain := r.autolinkdomain(text[start:])
+	if domain == 0 {
+		return "", 0
+	}
+	p := start + domain
+	for p < len(text) && !isHTMLSpace(text[p]) && text[p] != '<' {
+		p++
+	}
+	p = trimurl(text[:p])
+	if p <= start {
+		return "", 0
+	}
+	return prefix + string(text[:p]), p
+}
+
+/* autolinkdomain returns the length of the domain at the start of text.
+ * Its segments of letters, digits, "_" and "-" are separated by periods.
+ * There must be at least one period and the last two segments must not
+ * contain "_". It returns 0 if there is no such domain. The domain is
+ * checked from its end with cached scans, as the same run of bytes can be
+ * checked from every position in it. */
+func (r *Renderer) autolinkdomain(text []byte) int {
+	/* A trailing period ends the sentence */
+	p := r.trimright(text[:r.span(text, domainBytes)], ".")
+	if p == 0 {
+		return 0
+	}
+	domain := text[:p]
+	dot := r.lastindex(domain, ".")
+	if dot == -1 || domain[0] == '.' || r.lastindex(domain, "..") != -1 {
+		return 0
+	}
+	last := 0
+	if i := r.lastindex(domain[:dot], "."); i != -1 {
+		last = i + 1
+	}
+	if r.lastindex(domain[last:], "_") != -1 {
+		return 0
+	}
+	return p
+}
+
+/* autolinkemail returns the length of the mail address at the start of
+ * text, or 0 */
+func (r *Renderer) autolinkemail(text []byte) int {
+	p := r.span(text, mailBytes)
+	if p == 0 || p == len(text) || text[p] != '@' {
+		return 0
+	}
+	p++
+
+	/* The domain has no empty segment and ends before a period that is
+	 * not followed by another segment */
+	domain := text[p : p+r.span(text[p:], alnumBytes+"-_.")]
+	l := len(domain)
+	if i := r.index(domain, ".."); i != -1 {
+		l = i
+	} else if l > 0 && domain[l-1] == '.' {
+		l--
+	}
+	if l == 0 || domain[0] == '.' || r.index(domain[:l], ".") == -1 ||
+		domain[l-1] == '-' || domain[l-1] == '_' {
+		return 0
+	}
+	return p + l
+}
+
+/* trimurl returns the length of url without trailing punctuation, closing
+ * parentheses that have no opening one and a trailing entity reference */
+func trimurl(url []byte) int {
+	open := bytes.Count(url, []byte("("))
+	closing := bytes.Count(url, []byte(")"))
+	p := len(url)
+	for p > 0 {
+		switch c := url[p-1]; {
+		case strings.IndexByte("?!.,:*_~", c) != -1:
+			p--
+		case c == ')' && closing > open:
+			closing--
+			p--
+		case c == ';':
+			i := p - 2
+			for i >= 0 && isAlnum(url[i]) {
+				i--
+			}
+			if i < 0 || i == p-2 || url[i] != '&' {
+				return p
+			}
+			p = i
+		default:
+			return p
+		}
+	}
+	return p
+}
+
+/* hasprefixfold reports whether text starts with the ASCII prefix, ignoring
+ * case */
+func hasprefixfold(text []byte, prefix string) bool {
+	return len(text) >= len(prefix) && bytes.EqualFold(text[:len(prefix)], []byte(prefix))
+}
@@ -0,0 +1,52 @@
+package smu
+
+import "testing"
+
+func TestAutolink(t *testing.T) {
+	tests := []struct{ text, want string }{
+		{"HTTPS://X.COM ftp://f.org", "<p><a href=\"HTTPS://X.COM\">HTTPS://X.COM</a> <a href=\"ftp://f.org\">ftp://f.org</a></p>\n"},
+		{"www.example.com/path?q=1, www.x",
+			"<p><a href=\"http://www.example.com/path?q=1\">www.example.com/path?q=1</a>, <a href=\"http://www.x\">www.x</a></p>\n"},
+		{"www.ünï.com", "<p><a href=\"http://www.ünï.com\">www.ünï.com</a></p>\n"},
+		{"https://x.com/\"quoted\"", "<p><a href=\"https://x.com/&quot;quoted&quot;\">https://x.com/\"quoted\"</a></p>\n"},
+
+		/* Trailing punctuation and unbalanced parentheses are not part of
+		 * the link */
+		{"see https://example.com/a.", "<p>see <a href=\"https://example.com/a\">https://example.com/a</a>.</p>\n"},
+		{"(see https://en.wikipedia.org/wiki/Go_(language))",
+			"<p>(see <a href=\"https://en.wikipedia.org/wiki/Go_(language)\">https://en.wikipedia.org/wiki/Go_(language)</a>)</p>\n"},
+		{"https://x.com/a)) and https://x.com/(a)b)",
+			"<p><a href=\"https://x.com/a\">https://x.com/a</a>)) and <a href=\"https://x.com/(a)b\">https://x.com/(a)b</a>)</p>\n"},
+		{"*https://x.com*", "<p><em><a href=\"https://x.com\">https://x.com</a></em></p>\n"},
+		{"https://x.com/<b>", "<p><a href=\"https://x.com/\">https://x.com/</a><b></p>\n"},
+
+		/* Nor are entity references at the end, unlike those inside */
+		{"https://x.com/a&copy; https://x.com/a&b",
+			"<p><a href=\"https://x.com/a\">https://x.com/a</a>&amp;copy; <a href=\"https://x.com/a&amp;b\">https://x.com/a&amp;b</a></p>\n"},
+		{"https://x.com/a?b=c&lt;d", "<p><a href=\"https://x.com/a?b=c&amp;lt;d\">https://x.com/a?b=c&amp;lt;d</a></p>\n"},
+
+		/* Mail addresses */
+		{"mail a.b-c+d@example.co.uk. or x@y",
+			"<p>mail <a href=\"mailto:a.b-c+d@example.co.uk\">a.b-c+d@example.co.uk</a>. or x@y</p>\n"},
+		{"a@b.c_ a@b.c- a@b.c.", "<p>a@b.c_ a@b.c- <a href=\"mailto:a@b.c\">a@b.c</a>.</p>\n"},
+
+		/* Only at the start of words and outside other links and code */
+		{"foowww.example.com xhttps://x.com", "<p>foowww.example.com xhttps://x.com</p>\n"},
+		{"`https://x.com` <https://y.com> [t](https://z.com) [https://w.com](u)",
+			"<p><code>https://x.com</code> <a href=\"https://y.com\">https://y.com</a> <a href=\"https://z.com\">t</a> <a href=\"u\">https://w.com</a></p>\n"},
+	}
+	for _, tt := range tests {
+		if got := string(New(Options{Autolink: true}).Process([]byte(tt.text))); got != tt.want {
+			t.Errorf("%q:\ngot  %q\nwant %q", tt.text, got, tt.want)
+		}
+	}
+
+	text := []byte("https://x.com a@b.co")
+	if got, want := string(New(Options{}).Process(text)), "<p>https://x.com a@b.co</p>\n"; got != want {
+		t.Errorf("without Autolink:\ngot  %q\nwant %q", got, want)
+	}
+	want := "<p><a href=\"&#x6D;&#x61;i&#x6C;&#x74;&#x6F;:&#97;&#64;&#98;&#46;&#99;&#111;\">&#97;&#64;&#98;&#46;&#99;&#111;</a></p>\n"
+	if got := string(New(Options{Autolink: true, ObfuscateEmail: true}).Process([]byte("a@b.co"))); got != want {
+		t.Errorf("ObfuscateEmail:\ngot  %q\nwant %q", got, want)
+	}
+}
@@ -0,0 +1,69 @@
+package smu
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/* benchOptions are the option sets documents are benchmarked with */
+var benchOptions = map[string]Options{
+	"default": {},
+	"extensions": {Strikethrough: true, Mark: true, Superscript: true, Subscript: true,
+		Math: true, Autolink: true, HeadingAnchors: true},
+}
+
+/* benchInputs returns the documents to benchmark by name: testdoc, the
+ * golden files and plain prose */
+func benchInputs(b *testing.B) map[string][]byte {
+	inputs := map[string][]byte{}
+	files, _ := filepath.Glob(filepath.Join("testdata", "golden", "*.smu"))
+	for _, name := range append(files, "testdoc") {
+		text, err := os.ReadFile(name)
+		if err != nil {
+			b.Fatal(err)
+		}
+		inputs[strings.TrimSuffix(filepath.Base(name), ".smu")] = text
+	}
+	para := strings.Repeat("The quick brown fox jumps over the lazy dog, again and again. ", 20)
+	inputs["prose"] = []byte(strings.Repeat(para+"\n\n", 100))
+	return inputs
+}
+
+func BenchmarkProcess(b *testing.B) {
+	for name, text := range benchInputs(b) {
+		for optname, opts := range benchOptions {
+			b.Run(name+"/"+optname, func(b *testing.B) {
+				b.SetBytes(int64(len(text)))
+				b.ReportAllocs()
+				r := New(opts)
+				for b.Loop() {
+					r.Process(text)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkEscape(b *testing.B) {
+	text := bytes.Repeat([]byte("A title with \"quotes\", <angle brackets> & ünïcödé. "), 100)
+	r := New(Options{})
+	b.Run("hprint", func(b *testing.B) {
+		b.SetBytes(int64(len(text)))
+		b.ReportAllocs()
+		for b.Loop() {
+			r.out.Reset()
+			r.hprint(text)
+		}
+	})
+	b.Run("tprint", func(b *testing.B) {
+		b.SetBytes(int64(len(text)))
+		b.ReportAllocs()
+		for b.Loop() {
+			r.out.Reset()
+			r.tprint(text)
+		}
+	})
+}
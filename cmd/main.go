@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"html"
 	"io"
 	"log"
 	"net/http"
@@ -78,6 +79,7 @@ var (
 	tplpath   = "default"
 	csspath   = "default"
 	port      = 8080
+	opts      smu.Options
 )
 
 func main() {
@@ -94,7 +96,15 @@ func main() {
 	for i := 0; i < len(args); i++ {
 		switch args[i] {
 		case "-n", "--no-html":
-			smu.NoHTML = true
+			opts.NoHTML = true
+		case "-S", "--safe":
+			opts.Safe = true
+		case "-a", "--anchors":
+			opts.HeadingAnchors = true
+		case "-H", "--highlight":
+			opts.Highlight = true
+		case "-c", "--commonmark":
+			opts.CommonMark = true
 		case "-o", "--output":
 			if i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
 				outpath = args[i+1]
@@ -142,19 +152,52 @@ func main() {
 
 	text, err := io.ReadAll(infile)
 	must(err)
+	/* Documents may also start with a horizontal rule, so front matter
+	 * that does not parse is rendered as part of the text */
+	meta, text, err := frontmatter(text)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", err)
+	}
+
+	if !server && !useTemplate {
+		must(renderOutput(outpath, bytes.NewReader(text)))
+		return
+	}
+
 	if server {
-		must(processTemplate(text))
+		must(processTemplate(text, meta))
 		runserver()
 		return
 	}
 
-	if useTemplate {
-		must(processTemplate(text))
-		writeOutput(outpath, tplbuffer.Bytes())
-	} else {
-		result := smu.Process(text)
-		writeOutput(outpath, result)
+	must(processTemplate(text, meta))
+	writeOutput(outpath, tplbuffer.Bytes())
+}
+
+/* frontmatter splits the front matter off text. It is left as blank lines,
+ * so that the source positions count the lines of the file. */
+func frontmatter(text []byte) (map[string]any, []byte, error) {
+	meta, body, err := smu.FrontMatter(text)
+	if len(body) == len(text) {
+		return meta, body, err
+	}
+	lines := bytes.Count(text[:len(text)-len(body)], []byte("\n"))
+	return meta, append(bytes.Repeat([]byte("\n"), lines), body...), err
+}
+
+func renderOutput(outpath string, in io.Reader) error {
+	if outpath == "" {
+		return smu.New(opts).Render(os.Stdout, in)
+	}
+	f, err := os.Create(outpath)
+	if err != nil {
+		return err
+	}
+	if err := smu.New(opts).Render(f, in); err != nil {
+		f.Close()
+		return err
 	}
+	return f.Close()
 }
 
 func writeOutput(outpath string, result []byte) {
@@ -165,9 +208,28 @@ func writeOutput(outpath string, result []byte) {
 	}
 }
 
-func processTemplate(text []byte) (err error) {
-	body := string(smu.Process(text))
-	title := extractTitle(body)
+func processTemplate(text []byte, meta map[string]any) (err error) {
+	/* Pages get heading ids so the table of contents can link to them */
+	o := opts
+	o.HeadingIDs = true
+	r := smu.New(o)
+	doc := r.Parse(text)
+	toc := smu.TOC(doc)
+
+	var body, tocbuffer bytes.Buffer
+	if err := r.RenderNode(&body, doc); err != nil {
+		return err
+	}
+	if err := r.RenderTOC(&tocbuffer, toc); err != nil {
+		return err
+	}
+	title := extractTitle(toc)
+	if t, ok := meta["title"]; ok && t != nil {
+		title = html.EscapeString(fmt.Sprint(t))
+	}
+	if meta == nil {
+		meta = map[string]any{}
+	}
 
 	if tplpath == "default" {
 		tpl = template.Must(template.New("markdown").Parse(defaultTemplate))
@@ -188,22 +250,25 @@ func processTemplate(text []byte) (err error) {
 		}
 		css = string(bs)
 	}
+	if opts.Highlight {
+		css += smu.HighlightCSS
+	}
 
-	m := map[string]string{
+	m := map[string]any{
 		"title": title,
 		"css":   css,
-		"body":  body,
+		"body":  body.String(),
+		"toc":   tocbuffer.String(),
+		"meta":  meta,
 	}
 
 	return tpl.Execute(&tplbuffer, m)
 }
 
-func extractTitle(text string) string {
-	if h1Start := strings.Index(text, "<h1>"); h1Start != -1 {
-		h1End := strings.Index(text[h1Start:], "</h1>")
-		if h1End != -1 {
-			title := text[h1Start+4 : h1Start+h1End]
-			return strings.TrimSpace(title)
+func extractTitle(toc []*smu.TOCEntry) string {
+	for _, e := range toc {
+		if e.Level == 1 {
+			return html.EscapeString(strings.TrimSpace(e.Text))
 		}
 	}
 	return ""
@@ -230,11 +295,17 @@ func must(err error) {
 func Usage() {
 	usage := `Usage: smu [OPTION] ... [FILE]
     -n, --no-html         no html
+    -S, --safe            safe mode for untrusted input
+    -a, --anchors         heading ids and self-link anchors
+    -H, --highlight       highlight code, adds its stylesheet to the css
+    -c, --commonmark      parse by the CommonMark specification
     -i, --interactive     interactive mode
     -o, --output          string
           output file path
     -t, --template         string
-          template file path (default "default")
+          template file path (default "default"), the template
+          gets {{.title}}, {{.css}}, {{.body}}, {{.toc}} and the
+          front matter as {{.meta.key}}
     -css, --stylesheet     string
           css file path (default "default")
     -s, --server           start server
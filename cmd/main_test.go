@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/wasuppu/smu"
+)
+
+// TestFrontMatterSourcePos checks that source positions count the lines
+// of the front matter.
+func TestFrontMatterSourcePos(t *testing.T) {
+	tests := []struct{ text, want string }{
+		{"---\ntitle: x\n---\nhello\n", `<p data-sourcepos="4:1-4:5">hello</p>`},
+		{"+++\ntitle = \"x\"\n\n+++\n\n# hello\n", `<h1 data-sourcepos="6:1-6:7">hello</h1>`},
+	}
+	for _, cm := range []bool{false, true} {
+		for _, tt := range tests {
+			meta, body, err := frontmatter([]byte(tt.text))
+			if err != nil {
+				t.Fatalf("%q: %v", tt.text, err)
+			}
+			if meta != nil && meta["title"] != "x" {
+				t.Errorf("%q: meta %v", tt.text, meta)
+			}
+			out := string(smu.New(smu.Options{SourcePos: true, CommonMark: cm}).Process(body))
+			if !strings.Contains(out, tt.want) {
+				t.Errorf("CommonMark %v %q:\ngot  %q\nwant %q", cm, tt.text, out, tt.want)
+			}
+		}
+	}
+}
+
+// TestTemplateMeta checks that the keys of the front matter are given to
+// the template and that its title comes before the first heading.
+func TestTemplateMeta(t *testing.T) {
+	path := t.TempDir() + "/page.tpl"
+	if err := os.WriteFile(path, []byte("{{.title}}|{{with .meta.author}}{{.}}{{end}}|{{with .meta.date}}{{.}}{{end}}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	saved := tplpath
+	tplpath = path
+	defer func() { tplpath = saved }()
+
+	tests := []struct{ text, want string }{
+		{"---\ntitle: A & B\nauthor: me\ndate: 2024-01-02\n---\n# Heading\n", "A &amp; B|me|2024-01-02"},
+		{"+++\nauthor = \"me\"\n+++\n# Heading\n", "Heading|me|"},
+		{"# Heading\n", "Heading||"},
+	}
+	for _, tt := range tests {
+		meta, body, err := frontmatter([]byte(tt.text))
+		if err != nil {
+			t.Fatalf("%q: %v", tt.text, err)
+		}
+		tplbuffer.Reset()
+		if err := processTemplate(body, meta); err != nil {
+			t.Fatalf("%q: %v", tt.text, err)
+		}
+		if got := tplbuffer.String(); got != tt.want {
+			t.Errorf("%q:\ngot  %q\nwant %q", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package smu
+
+import (
+	"fmt"
+	"strings"
+)
+
+/* cmhtml writes n as the reference implementation of CommonMark does */
+func (r *Renderer) cmhtml(n *Node) {
+	switch n.Type {
+	case Document:
+		r.cmchildren(n)
+	case Paragraph:
+		if p := n.Parent; p != nil && p.Parent != nil && p.Parent.Type == List && p.Parent.Tight {
+			r.cmchildren(n)
+			return
+		}
+		r.cr()
+		r.out.WriteString("<p")
+		r.sourcepos(n)
+		r.out.WriteString(">")
+		r.cmchildren(n)
+		r.out.WriteString("</p>")
+		r.cr()
+	case Heading:
+		id := ""
+		if r.opts.HeadingIDs || r.opts.HeadingAnchors {
+			id = n.ID
+		}
+		r.cr()
+		fmt.Fprintf(&r.out, "<h%d", n.Level)
+		r.sourcepos(n)
+		if id != "" {
+			r.out.WriteString(" id=\"")
+			r.cmescape([]byte(id))
+			r.out.WriteString("\"")
+		}
+		r.out.WriteString(">")
+		r.cmchildren(n)
+		if id != "" && r.opts.HeadingAnchors {
+			r.out.WriteString("<a class=\"anchor\" href=\"#")
+			r.cmescape([]byte(id))
+			r.out.WriteString("\">#</a>")
+		}
+		fmt.Fprintf(&r.out, "</h%d>", n.Level)
+		r.cr()
+	case List:
+		tag := "ul"
+		if n.Ordered {
+			tag = "ol"
+		}
+		r.cr()
+		r.out.WriteString("<" + tag)
+		if n.Ordered && n.Start != 1 {
+			fmt.Fprintf(&r.out, " start=\"%d\"", n.Start)
+		}
+		r.sourcepos(n)
+		r.out.WriteString(">")
+		r.cr()
+		r.cmchildren(n)
+		r.cr()
+		r.out.WriteString("</" + tag + ">")
+		r.cr()
+	case ListItem:
+		r.out.WriteString("<li")
+		r.sourcepos(n)
+		r.out.WriteString(">")
+		r.cmchildren(n)
+		r.out.WriteString("</li>")
+		r.cr()
+	case CodeBlock:
+		r.cr()
+		r.out.WriteString("<pre")
+		r.sourcepos(n)
+		r.out.WriteString("><code")
+		lang := strings.Fields(n.Info)
+		if len(lang) > 0 {
+			r.out.WriteString(" class=\"language-")
+			r.cmescape([]byte(lang[0]))
+			r.out.WriteString("\"")
+		}
+		r.out.WriteString(">")
+		if h := r.highlighter(codelang(n.Info)); h != nil && n.Fenced {
+			if err := h.Highlight(&r.out, codelang(n.Info), n.Literal); err != nil && r.err == nil {
+				r.err = err
+			}
+		} else {
+			r.cmescape(n.Literal)
+		}
+		r.out.WriteString("</code></pre>")
+		r.cr()
+	case HTMLBlock:
+		r.cr()
+		r.cmraw(n.Literal)
+		r.cr()
+	case Blockquote:
+		r.cr()
+		r.out.WriteString("<blockquote")
+		r.sourcepos(n)
+		r.out.WriteString(">")
+		r.cr()
+		r.cmchildren(n)
+		r.cr()
+		r.out.WriteString("</blockquote>")
+		r.cr()
+	case HorizontalRule:
+		r.cr()
+		r.out.WriteString("<hr")
+		r.sourcepos(n)
+		r.out.WriteString(" />")
+		r.cr()
+	case Text:
+		r.cmescape(n.Literal)
+	case LineBreak:
+		r.out.WriteString("<br />")
+		r.cr()
+	case Code:
+		r.out.WriteString("<code>")
+		r.cmescape(n.Literal)
+		r.out.WriteString("</code>")
+	case RawHTML:
+		r.cmraw(n.Literal)
+	case Link:
+		l := r.runhooks(r.linkHooks, n)
+		if l.HTML != nil {
+			r.out.Write(l.HTML)
+			return
+		}
+		r.out.WriteString("<a href=\"")
+		r.cmescape([]byte(r.linkdest(l.Dest)))
+		r.out.WriteString("\"")
+		if l.Title != "" {
+			r.out.WriteString(" title=\"")
+			r.cmescape([]byte(l.Title))
+			r.out.WriteString("\"")
+		}
+		for _, a := range l.Attrs {
+			r.out.WriteString(" ")
+			r.attr(a)
+		}
+		r.out.WriteString(">")
+		r.cmchildren(n)
+		r.out.WriteString("</a>")
+	case Image:
+		l := r.runhooks(r.imageHooks, n)
+		if l.HTML != nil {
+			r.out.Write(l.HTML)
+			return
+		}
+		r.out.WriteString("<img src=\"")
+		r.cmescape([]byte(r.linkdest(l.Dest)))
+		r.out.WriteString("\" alt=\"")
+		r.cmalt(n)
+		r.out.WriteString("\"")
+		if l.Title != "" {
+			r.out.WriteString(" title=\"")
+			r.cmescape([]byte(l.Title))
+			r.out.WriteString("\"")
+		}
+		for _, a := range l.Attrs {
+			r.out.WriteString(" ")
+			r.attr(a)
+		}
+		r.out.WriteString(" />")
+	case Emphasis:
+		r.out.WriteString("<em>")
+		r.cmchildren(n)
+		r.out.WriteString("</em>")
+	case Strong:
+		r.out.WriteString("<strong>")
+		r.cmchildren(n)
+		r.out.WriteString("</strong>")
+	default:
+		r.html(n)
+	}
+}
+
+func (r *Renderer) cmchildren(n *Node) {
+	for _, c := range n.Children {
+		r.cmhtml(c)
+	}
+}
+
+/* cr starts a new line unless the output is at the start of one */
+func (r *Renderer) cr() {
+	if b := r.out.Bytes(); len(b) > 0 && b[len(b)-1] != '\n' {
+		r.out.WriteByte('\n')
+	}
+}
+
+/* cmraw writes raw HTML, which is sanitized in safe mode */
+func (r *Renderer) cmraw(text []byte) {
+	if r.opts.Safe {
+		r.sanitize(text)
+	} else {
+		r.out.Write(text)
+	}
+}
+
+/* cmalt writes the content of image n as the text of its alt attribute.
+ * Raw HTML is text there, so it is escaped like all other content. */
+func (r *Renderer) cmalt(n *Node) {
+	Walk(n, func(c *Node, entering bool) WalkStatus {
+		if entering && c != n {
+			switch c.Type {
+			case Text, Code, RawHTML:
+				r.cmescape(c.Literal)
+			}
+		}
+		return GoToNext
+	})
+}
+
+/* cmescape writes text with &, <, > and " escaped */
+func (r *Renderer) cmescape(text []byte) {
+	for _, c := range text {
+		switch c {
+		case '&':
+			r.out.WriteString("&amp;")
+		case '<':
+			r.out.WriteString("&lt;")
+		case '>':
+			r.out.WriteString("&gt;")
+		case '"':
+			r.out.WriteString("&quot;")
+		default:
+			r.out.WriteByte(c)
+		}
+	}
+}
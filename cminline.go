@@ -0,0 +1,927 @@
+package smu
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	cmTagName      = `[A-Za-z][A-Za-z0-9-]*`
+	cmAttrName     = `[a-zA-Z_:][a-zA-Z0-9:._-]*`
+	cmAttrValue    = `(?:[^"'=<>` + "`" + `\x00-\x20]+|'[^']*'|"[^"]*")`
+	cmAttribute    = `(?:\s+` + cmAttrName + `(?:\s*=\s*` + cmAttrValue + `)?)`
+	cmOpenTag      = `<` + cmTagName + cmAttribute + `*\s*/?>`
+	cmCloseTag     = `</` + cmTagName + `\s*[>]`
+	cmEscapable    = "!\"#$%&'()*+,./:;<=>?@[\\]^_`{|}~-"
+	cmLinkMaxLabel = 999
+	/* Nested parentheses in destinations are limited as the spec allows,
+	 * so that no destination is searched from every "(" */
+	cmLinkMaxParens = 32
+)
+
+var (
+	cmHTMLTag   = regexp.MustCompile(`(?i)^(?:` + cmOpenTag + `|` + cmCloseTag + `)`)
+	cmEntity    = regexp.MustCompile(`^&(?:#[xX][a-fA-F0-9]{1,6}|#[0-9]{1,7}|[A-Za-z][A-Za-z0-9]{1,31});`)
+	cmEmailLink = regexp.MustCompile(`^<([a-zA-Z0-9.!#$%&'*+/=?^_` + "`" + `{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*)>`)
+	cmURILink   = regexp.MustCompile(`^<[A-Za-z][A-Za-z0-9.+-]{1,31}:[^<>\x00-\x20]*>`)
+	cmLinkTitle = regexp.MustCompile(`^(?:"(?:\\[\s\S]|[^\\"\x00])*"|'(?:\\[\s\S]|[^\\'\x00])*'|\((?:\\[\s\S]|[^\\()\x00])*\))`)
+)
+
+/* cmdelim is a run of "*" or "_" that may open or close emphasis */
+type cmdelim struct {
+	c          byte
+	n, orig    int
+	item       *cmitem
+	prev, next *cmdelim
+	canOpen    bool
+	canClose   bool
+}
+
+/* cmbracket is a "[" or "![" that may start a link or image */
+type cmbracket struct {
+	item         *cmitem
+	prev         *cmbracket
+	prevDelim    *cmdelim
+	index        int
+	image        bool
+	active       bool
+	bracketAfter bool
+}
+
+/* cmitem is an inline of the block being parsed. Emphasis and links take
+ * the inlines between their delimiters, which are always at the top
+ * level, so the top level is a linked list until the block is done. */
+type cmitem struct {
+	node       *Node
+	prev, next *cmitem
+	depth      int /* nesting depth of the inlines in node */
+}
+
+type cminline struct {
+	p           *cmparser
+	subject     []byte
+	pos         int
+	first, last *cmitem
+	delims      *cmdelim
+	brackets    *cmbracket
+
+	ticks  map[int]int /* the last position of backtick runs by length */
+	ticked bool        /* ticks has all runs up to the end */
+}
+
+/* inlines parses the inline content of the paragraph or heading block */
+func (p *cmparser) inlines(block *Node, content []byte) {
+	r := p.r
+	in := &cminline{p: p, subject: bytes.Trim(content, " \t\n\r\f\v")}
+	for in.pos < len(in.subject) {
+		/* The text left when the budget runs out is plain text */
+		if !r.step() {
+			in.add(cmtext(in.subject[in.pos:]))
+			break
+		}
+		in.parse()
+	}
+	in.emphasis(nil)
+
+	depth := p.state[block].depth
+	for it := in.first; it != nil; it = it.next {
+		if !r.within(depth + it.depth) {
+			cmflatten(it.node, r.maxdepth()-depth)
+		}
+		block.AppendChild(it.node)
+	}
+	cmmergetext(block)
+}
+
+/* add appends n to the top level */
+func (in *cminline) add(n *Node) *cmitem {
+	it := &cmitem{node: n, prev: in.last}
+	if in.last == nil {
+		in.first = it
+	} else {
+		in.last.next = it
+	}
+	in.last = it
+	return it
+}
+
+/* unlink removes it from the top level */
+func (in *cminline) unlink(it *cmitem) {
+	if it.prev == nil {
+		in.first = it.next
+	} else {
+		it.prev.next = it.next
+	}
+	if it.next == nil {
+		in.last = it.prev
+	} else {
+		it.next.prev = it.prev
+	}
+}
+
+/* wrap moves the inlines between first and last, or up to the end if last
+ * is nil, into n, which takes their place */
+func (in *cminline) wrap(first, last *cmitem, n *Node) {
+	w := &cmitem{node: n, prev: first, next: last, depth: 1}
+	for it := first.next; it != last; it = it.next {
+		n.AppendChild(it.node)
+		w.depth = max(w.depth, it.depth+1)
+	}
+	first.next = w
+	if last == nil {
+		in.last = w
+	} else {
+		last.prev = w
+	}
+}
+
+/* cmflatten replaces the inlines nested in n deeper than depth by their
+ * text */
+func cmflatten(n *Node, depth int) {
+	if depth > 1 {
+		for _, c := range n.Children {
+			cmflatten(c, depth-1)
+		}
+		return
+	}
+	if len(n.Children) == 0 {
+		return
+	}
+	var text []byte
+	stack := slices.Clone(n.Children)
+	slices.Reverse(stack)
+	for len(stack) > 0 {
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		switch c.Type {
+		case LineBreak:
+			text = append(text, '\n')
+		case Link, Image, Emphasis, Strong:
+			for i := len(c.Children) - 1; i >= 0; i-- {
+				stack = append(stack, c.Children[i])
+			}
+		default:
+			text = append(text, c.Literal...)
+		}
+	}
+	n.Children = nil
+	n.AppendChild(cmtext(text))
+}
+
+/* cmmergetext merges adjacent text nodes in the tree of n */
+func cmmergetext(n *Node) {
+	Walk(n, func(c *Node, entering bool) WalkStatus {
+		if !entering || len(c.Children) < 2 {
+			return GoToNext
+		}
+		children := c.Children[:1]
+		for _, next := range c.Children[1:] {
+			last := children[len(children)-1]
+			if last.Type == Text && next.Type == Text {
+				last.Literal = append(last.Literal, next.Literal...)
+				continue
+			}
+			children = append(children, next)
+		}
+		c.Children = children
+		return GoToNext
+	})
+}
+
+func cmtext(s []byte) *Node {
+	n := NewNode(Text)
+	n.Literal = append([]byte(nil), s...)
+	return n
+}
+
+func (in *cminline) peek() int {
+	if in.pos < len(in.subject) {
+		return int(in.subject[in.pos])
+	}
+	return -1
+}
+
+/* match returns the match of re at the current position and moves past it */
+func (in *cminline) match(re *regexp.Regexp) []byte {
+	m := re.Find(in.subject[in.pos:])
+	if m != nil {
+		in.pos += len(m)
+	}
+	return m
+}
+
+func (in *cminline) parse() {
+	c := in.subject[in.pos]
+	ok := false
+	switch c {
+	case '\n':
+		ok = in.newline()
+	case '\\':
+		ok = in.backslash()
+	case '`':
+		ok = in.backticks()
+	case '*', '_':
+		ok = in.delim(c)
+	case '[':
+		in.pos++
+		in.addbracket(in.add(cmtext([]byte("["))), in.pos-1, false)
+		ok = true
+	case '!':
+		in.pos++
+		if in.peek() == '[' {
+			in.pos++
+			in.addbracket(in.add(cmtext([]byte("!["))), in.pos-1, true)
+		} else {
+			in.add(cmtext([]byte("!")))
+		}
+		ok = true
+	case ']':
+		ok = in.closebracket()
+	case '<':
+		ok = in.autolink() || in.htmltag()
+	case '&':
+		ok = in.entity()
+	default:
+		ok = in.text()
+	}
+	if !ok {
+		in.pos++
+		in.add(cmtext([]byte{c}))
+	}
+}
+
+func (in *cminline) text() bool {
+	start := in.pos
+	for in.pos < len(in.subject) && bytes.IndexByte([]byte("\n`[]\\!<&*_"), in.subject[in.pos]) == -1 {
+		in.pos++
+	}
+	if in.pos == start {
+		return false
+	}
+	in.add(cmtext(in.subject[start:in.pos]))
+	return true
+}
+
+/* newline adds a soft line break, or a hard one after two spaces */
+func (in *cminline) newline() bool {
+	in.pos++
+	var last *Node
+	if in.last != nil {
+		last = in.last.node
+	}
+	if last != nil && last.Type == Text && bytes.HasSuffix(last.Literal, []byte(" ")) {
+		hard := bytes.HasSuffix(last.Literal, []byte("  "))
+		last.Literal = bytes.TrimRight(last.Literal, " ")
+		if hard {
+			in.add(NewNode(LineBreak))
+		} else {
+			in.add(cmtext([]byte("\n")))
+		}
+	} else {
+		in.add(cmtext([]byte("\n")))
+	}
+	for in.pos < len(in.subject) && in.subject[in.pos] == ' ' {
+		in.pos++
+	}
+	return true
+}
+
+func (in *cminline) backslash() bool {
+	in.pos++
+	switch c := in.peek(); {
+	case c == '\n':
+		in.pos++
+		in.add(NewNode(LineBreak))
+	case c != -1 && strings.IndexByte(cmEscapable, byte(c)) != -1:
+		in.add(cmtext([]byte{byte(c)}))
+		in.pos++
+	default:
+		in.add(cmtext([]byte("\\")))
+	}
+	return true
+}
+
+func (in *cminline) backticks() bool {
+	start := in.pos
+	for in.pos < len(in.subject) && in.subject[in.pos] == '`' {
+		in.pos++
+	}
+	ticks := in.pos - start
+	after := in.pos
+	/* Once a search went to the end, the last runs are known */
+	if last, ok := in.ticks[ticks]; in.ticked && (!ok || last < after) {
+		in.add(cmtext(in.subject[start:after]))
+		return true
+	}
+	if in.ticks == nil {
+		in.ticks = map[int]int{}
+	}
+	for p := after; p < len(in.subject); {
+		if in.subject[p] != '`' {
+			p++
+			continue
+		}
+		run := p
+		for p < len(in.subject) && in.subject[p] == '`' {
+			p++
+		}
+		in.ticks[p-run] = max(in.ticks[p-run], run)
+		if p-run != ticks {
+			continue
+		}
+		contents := bytes.ReplaceAll(in.subject[after:run], []byte("\n"), []byte(" "))
+		/* One space is stripped from both ends unless there are only spaces */
+		if len(contents) > 2 && contents[0] == ' ' && contents[len(contents)-1] == ' ' &&
+			len(bytes.Trim(contents, " ")) > 0 {
+			contents = contents[1 : len(contents)-1]
+		}
+		n := NewNode(Code)
+		n.Literal = append([]byte(nil), contents...)
+		in.add(n)
+		in.pos = p
+		return true
+	}
+	in.ticked = true
+	in.pos = after
+	in.add(cmtext(in.subject[start:after]))
+	return true
+}
+
+/* delim adds a run of c as text and remembers it if it may open or close
+ * emphasis */
+func (in *cminline) delim(c byte) bool {
+	start := in.pos
+	for in.pos < len(in.subject) && in.subject[in.pos] == c {
+		in.pos++
+	}
+	n := in.pos - start
+
+	before, after := '\n', '\n'
+	if start > 0 {
+		before, _ = utf8.DecodeLastRune(in.subject[:start])
+	}
+	if in.pos < len(in.subject) {
+		after, _ = utf8.DecodeRune(in.subject[in.pos:])
+	}
+	afterSpace, afterPunct := cmspace(after), cmpunct(after)
+	beforeSpace, beforePunct := cmspace(before), cmpunct(before)
+	left := !afterSpace && (!afterPunct || beforeSpace || beforePunct)
+	right := !beforeSpace && (!beforePunct || afterSpace || afterPunct)
+	canOpen, canClose := left, right
+	if c == '_' {
+		canOpen = left && (!right || beforePunct)
+		canClose = right && (!left || afterPunct)
+	}
+
+	item := in.add(cmtext(in.subject[start:in.pos]))
+	if canOpen || canClose {
+		d := &cmdelim{c: c, n: n, orig: n, item: item, prev: in.delims,
+			canOpen: canOpen, canClose: canClose}
+		if in.delims != nil {
+			in.delims.next = d
+		}
+		in.delims = d
+	}
+	return true
+}
+
+func cmspace(r rune) bool {
+	return r == '\t' || r == '\n' || r == '\f' || r == '\r' || unicode.Is(unicode.Zs, r)
+}
+
+func cmpunct(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+func (in *cminline) removedelim(d *cmdelim) {
+	if d.prev != nil {
+		d.prev.next = d.next
+	}
+	if d.next == nil {
+		in.delims = d.prev
+	} else {
+		d.next.prev = d.prev
+	}
+}
+
+/* emphasis turns the delimiters above bottom into emphasis and removes
+ * them */
+func (in *cminline) emphasis(bottom *cmdelim) {
+	var openersBottom [12]*cmdelim
+	for i := range openersBottom {
+		openersBottom[i] = bottom
+	}
+
+	closer := in.delims
+	for closer != nil && closer.prev != bottom {
+		closer = closer.prev
+	}
+	for closer != nil {
+		if !closer.canClose {
+			closer = closer.next
+			continue
+		}
+		i := closer.orig % 3
+		if closer.canOpen {
+			i += 3
+		}
+		if closer.c == '*' {
+			i += 6
+		}
+
+		opener := closer.prev
+		found := false
+		for opener != nil && opener != bottom && opener != openersBottom[i] {
+			odd := (closer.canOpen || opener.canClose) && closer.orig%3 != 0 &&
+				(opener.orig+closer.orig)%3 == 0
+			if opener.c == closer.c && opener.canOpen && !odd {
+				found = true
+				break
+			}
+			opener = opener.prev
+		}
+
+		old := closer
+		if !found {
+			closer = closer.next
+			/* No later closer finds an opener below this one */
+			openersBottom[i] = old.prev
+			if !old.canOpen {
+				in.removedelim(old)
+			}
+			continue
+		}
+
+		use := 1
+		if closer.n >= 2 && opener.n >= 2 {
+			use = 2
+		}
+		opener.n -= use
+		closer.n -= use
+		opener.item.node.Literal = opener.item.node.Literal[:opener.n]
+		closer.item.node.Literal = closer.item.node.Literal[:closer.n]
+
+		emph := NewNode(Emphasis)
+		if use == 2 {
+			emph.Type = Strong
+		}
+		in.wrap(opener.item, closer.item, emph)
+
+		/* Delimiters inside the emphasis are text now */
+		opener.next = closer
+		closer.prev = opener
+
+		if opener.n == 0 {
+			in.unlink(opener.item)
+			in.removedelim(opener)
+		}
+		if closer.n == 0 {
+			in.unlink(closer.item)
+			next := closer.next
+			in.removedelim(closer)
+			closer = next
+		}
+	}
+
+	for in.delims != nil && in.delims != bottom {
+		in.removedelim(in.delims)
+	}
+}
+
+func (in *cminline) addbracket(item *cmitem, index int, image bool) {
+	if in.brackets != nil {
+		in.brackets.bracketAfter = true
+	}
+	in.brackets = &cmbracket{item: item, prev: in.brackets, prevDelim: in.delims,
+		index: index, image: image, active: true}
+}
+
+/* closebracket turns "[text]" into a link or image if it is followed by a
+ * destination or matches a reference */
+func (in *cminline) closebracket() bool {
+	in.pos++
+	start := in.pos
+	opener := in.brackets
+	if opener == nil {
+		in.add(cmtext([]byte("]")))
+		return true
+	}
+	if !opener.active {
+		in.add(cmtext([]byte("]")))
+		in.brackets = opener.prev
+		return true
+	}
+
+	var dest, title string
+	matched := false
+
+	/* Inline link */
+	save := in.pos
+	if in.peek() == '(' {
+		in.pos++
+		in.spnl()
+		if d, ok := in.destination(); ok {
+			dest = d
+			before := in.pos
+			in.spnl()
+			if in.pos > before {
+				if t, ok := in.title(); ok {
+					title = t
+				}
+			}
+			in.spnl()
+			if in.peek() == ')' {
+				in.pos++
+				matched = true
+			}
+		}
+		if !matched {
+			in.pos = save
+		}
+	}
+
+	/* Reference link */
+	if !matched {
+		beforeLabel := in.pos
+		n := in.linklabel()
+		var label []byte
+		if n > 2 {
+			label = in.subject[beforeLabel : beforeLabel+n]
+		} else if !opener.bracketAfter {
+			label = in.subject[opener.index:start]
+		}
+		if n == 0 {
+			in.pos = save
+		}
+		if label != nil && len(label) <= cmLinkMaxLabel+2 {
+			if ref, ok := in.p.refs[cmlabel(label)]; ok {
+				dest, title = ref.dest, ref.title
+				matched = true
+			}
+		}
+	}
+
+	if !matched {
+		in.brackets = opener.prev
+		in.pos = start
+		in.add(cmtext([]byte("]")))
+		return true
+	}
+
+	n := NewNode(Link)
+	if opener.image {
+		n.Type = Image
+	}
+	n.Dest = dest
+	n.Title = title
+	in.emphasis(opener.prevDelim)
+	in.wrap(opener.item, nil, n)
+	in.brackets = opener.prev
+	in.unlink(opener.item)
+
+	/* There are no links in links */
+	if !opener.image {
+		for b := in.brackets; b != nil; b = b.prev {
+			if !b.image {
+				b.active = false
+			}
+		}
+	}
+	return true
+}
+
+/* spnl skips spaces and up to one line ending */
+func (in *cminline) spnl() {
+	for in.pos < len(in.subject) && (in.subject[in.pos] == ' ' || in.subject[in.pos] == '\t') {
+		in.pos++
+	}
+	if in.peek() == '\n' {
+		in.pos++
+		for in.pos < len(in.subject) && (in.subject[in.pos] == ' ' || in.subject[in.pos] == '\t') {
+			in.pos++
+		}
+	}
+}
+
+/* destination reads a link destination, either in angle brackets or
+ * without spaces and with balanced parentheses */
+func (in *cminline) destination() (string, bool) {
+	s := in.subject
+	if in.peek() == '<' {
+		for p := in.pos + 1; p < len(s); p++ {
+			switch s[p] {
+			case '\\':
+				p++
+			case '\n', '<':
+				return "", false
+			case '>':
+				dest := cmunescape(s[in.pos+1 : p])
+				in.pos = p + 1
+				return cmnormalizeurl(dest), true
+			}
+		}
+		return "", false
+	}
+
+	start := in.pos
+	parens := 0
+	p := in.pos
+loop:
+	for p < len(s) {
+		switch c := s[p]; {
+		case c == '\\' && p+1 < len(s) && strings.IndexByte(cmEscapable, s[p+1]) != -1:
+			p += 2
+		case c == '(':
+			parens++
+			if parens > cmLinkMaxParens {
+				return "", false
+			}
+			p++
+		case c == ')':
+			if parens < 1 {
+				break loop
+			}
+			parens--
+			p++
+		case c <= ' ' || c == 0x7f:
+			break loop
+		default:
+			p++
+		}
+	}
+	if p == start && (p == len(s) || s[p] != ')') || parens != 0 {
+		return "", false
+	}
+	in.pos = p
+	return cmnormalizeurl(cmunescape(s[start:p])), true
+}
+
+func (in *cminline) title() (string, bool) {
+	m := in.match(cmLinkTitle)
+	if m == nil {
+		return "", false
+	}
+	return string(cmunescape(m[1 : len(m)-1])), true
+}
+
+/* linklabel returns the length of the link label at the current position,
+ * or 0 */
+func (in *cminline) linklabel() int {
+	s := in.subject
+	if in.peek() != '[' {
+		return 0
+	}
+	for p := in.pos + 1; p < len(s) && p-in.pos <= cmLinkMaxLabel+1; p++ {
+		switch s[p] {
+		case '\\':
+			p++
+		case '[':
+			return 0
+		case ']':
+			n := p + 1 - in.pos
+			in.pos = p + 1
+			return n
+		}
+	}
+	return 0
+}
+
+/* cmlabel normalises a link label: the brackets are removed, white space
+ * is collapsed and case is folded */
+func cmlabel(label []byte) string {
+	label = bytes.Trim(label[1:len(label)-1], " \t\r\n")
+	var b strings.Builder
+	space := false
+	for _, c := range label {
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteByte(c)
+	}
+	return strings.ReplaceAll(strings.ToUpper(strings.ToLower(b.String())), "ß", "SS")
+}
+
+/* reference reads the link reference definitions at the start of s and
+ * returns their length, or 0 */
+func (p *cmparser) reference(s []byte) int {
+	in := &cminline{p: p, subject: s}
+	n := in.linklabel()
+	if n == 0 {
+		return 0
+	}
+	label := s[:n]
+	if in.peek() != ':' {
+		return 0
+	}
+	in.pos++
+	in.spnl()
+	dest, ok := in.destination()
+	if !ok {
+		return 0
+	}
+
+	beforeTitle := in.pos
+	in.spnl()
+	title := ""
+	hasTitle := false
+	if in.pos != beforeTitle {
+		title, hasTitle = in.title()
+	}
+	if !hasTitle {
+		in.pos = beforeTitle
+	}
+
+	/* Nothing but spaces may follow on the line */
+	if !in.atlineend() {
+		if !hasTitle {
+			return 0
+		}
+		/* The title may be a paragraph of its own */
+		title = ""
+		in.pos = beforeTitle
+		if !in.atlineend() {
+			return 0
+		}
+	}
+	norm := cmlabel(label)
+	if norm == "" {
+		return 0
+	}
+	if _, ok := p.refs[norm]; !ok {
+		p.refs[norm] = linkref{dest: dest, title: title}
+	}
+	return in.pos
+}
+
+/* atlineend skips spaces up to the end of the line and past it */
+func (in *cminline) atlineend() bool {
+	p := in.pos
+	for p < len(in.subject) && (in.subject[p] == ' ' || in.subject[p] == '\t') {
+		p++
+	}
+	if p < len(in.subject) && in.subject[p] != '\n' {
+		return false
+	}
+	in.pos = min(p+1, len(in.subject))
+	return true
+}
+
+func (in *cminline) autolink() bool {
+	var dest string
+	m := in.match(cmEmailLink)
+	if m != nil {
+		dest = cmnormalizeurl([]byte(mailto + string(m[1:len(m)-1])))
+	} else if m = in.match(cmURILink); m != nil {
+		dest = cmnormalizeurl(m[1 : len(m)-1])
+	} else {
+		return false
+	}
+	n := NewNode(Link)
+	n.Autolink = true
+	n.Dest = dest
+	n.AppendChild(cmtext(m[1 : len(m)-1]))
+	in.add(n).depth = 1
+	return true
+}
+
+func (in *cminline) htmltag() bool {
+	if in.p.r.opts.NoHTML {
+		return false
+	}
+	m := in.htmlend()
+	if m == nil {
+		return false
+	}
+	n := NewNode(RawHTML)
+	n.Literal = append([]byte(nil), m...)
+	in.add(n)
+	return true
+}
+
+/* htmlend matches the raw HTML at the current position and moves past it.
+ * Comments, instructions, declarations and CDATA sections may end far
+ * away, so their ends are searched with the caches of the renderer. */
+func (in *cminline) htmlend() []byte {
+	s := in.subject[in.pos:]
+	open, end := 0, ""
+	switch {
+	case hasprefix(s, "<!-->"):
+		open = 5
+	case hasprefix(s, "<!--->"):
+		open = 6
+	case hasprefix(s, "<!--"):
+		open, end = 4, "-->"
+	case hasprefix(s, "<?"):
+		open, end = 2, "?>"
+	case len(s) >= 9 && bytes.EqualFold(s[:9], []byte("<![CDATA[")):
+		open, end = 9, "]]>"
+	case len(s) > 2 && s[1] == '!' && isLetter(s[2]):
+		open, end = 2, ">"
+	default:
+		return in.match(cmHTMLTag)
+	}
+	if end != "" {
+		i := in.p.r.index(s[open:], end)
+		if i == -1 {
+			return nil
+		}
+		open += i + len(end)
+	}
+	in.pos += open
+	return s[:open]
+}
+
+func (in *cminline) entity() bool {
+	m := in.match(cmEntity)
+	if m == nil {
+		return false
+	}
+	in.add(cmtext([]byte(cmdecodeentity(string(m)))))
+	return true
+}
+
+/* cmdecodeentity returns the character of an entity or numeric character
+ * reference, or the reference itself if it is not known */
+func cmdecodeentity(ref string) string {
+	if ref[1] == '#' {
+		var n int64
+		var err error
+		if ref[2] == 'x' || ref[2] == 'X' {
+			n, err = strconv.ParseInt(ref[3:len(ref)-1], 16, 32)
+		} else {
+			n, err = strconv.ParseInt(ref[2:len(ref)-1], 10, 32)
+		}
+		if err != nil || n == 0 || n > unicode.MaxRune || (n >= 0xd800 && n <= 0xdfff) {
+			return "�"
+		}
+		return string(rune(n))
+	}
+	/* Names decode to one or two characters, longer results are legacy
+	 * entities without semicolon followed by text */
+	s := html.UnescapeString(ref)
+	if s == ref || utf8.RuneCountInString(s) > 2 {
+		return ref
+	}
+	return s
+}
+
+/* cmunescape resolves backslash escapes and entities */
+func cmunescape(s []byte) []byte {
+	if bytes.IndexAny(s, "\\&") == -1 {
+		return s
+	}
+	var out []byte
+	for p := 0; p < len(s); p++ {
+		switch {
+		case s[p] == '\\' && p+1 < len(s) && strings.IndexByte(cmEscapable, s[p+1]) != -1:
+			p++
+			out = append(out, s[p])
+		case s[p] == '&':
+			if m := cmEntity.Find(s[p:]); m != nil {
+				out = append(out, cmdecodeentity(string(m))...)
+				p += len(m) - 1
+			} else {
+				out = append(out, '&')
+			}
+		default:
+			out = append(out, s[p])
+		}
+	}
+	return out
+}
+
+/* cmnormalizeurl percent-encodes the characters of a URL that are not
+ * allowed in it, keeping existing escapes */
+func cmnormalizeurl(url []byte) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for p := 0; p < len(url); p++ {
+		c := url[p]
+		switch {
+		case c == '%' && p+2 < len(url) && ishex(url[p+1]) && ishex(url[p+2]):
+			b.WriteByte(c)
+		case c < utf8.RuneSelf && (isAlnum(c) || strings.IndexByte(";/?:@&=+$,-_.!~*'()#", c) != -1):
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&15])
+		}
+	}
+	return b.String()
+}
+
+func ishex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
@@ -0,0 +1,738 @@
+package smu
+
+import (
+	"bytes"
+	"regexp"
+)
+
+/* The CommonMark parser follows the parsing strategy of the spec: lines
+ * are fed to the tree of open blocks, which they continue, close or add
+ * to. Paragraphs and headings are parsed as inlines once all blocks are
+ * known, see cminline.go. */
+
+/* cmblock is the parser state of a block */
+type cmblock struct {
+	open    bool
+	content []byte
+	pos     SourcePos
+	depth   int /* nesting depth, 0 for the document */
+
+	/* List and ListItem */
+	bullet       byte /* bullet character, 0 for ordered lists */
+	delim        byte /* '.' or ')' of ordered lists */
+	padding      int
+	markerOffset int
+
+	/* fenced CodeBlock */
+	fenceChar   byte
+	fenceLength int
+	fenceOffset int
+
+	htmlType int /* kind of HTMLBlock, 1 to 7 */
+}
+
+type cmparser struct {
+	r     *Renderer
+	doc   *Node
+	tip   *Node /* innermost open block */
+	state map[*Node]*cmblock
+	refs  map[string]linkref
+
+	oldtip      *Node
+	lastMatched *Node
+	allClosed   bool
+
+	line                 []byte
+	lineNumber           int
+	offset, column       int
+	nextNonspace         int
+	nextNonspaceColumn   int
+	indent               int
+	indented, blank      bool
+	partiallyConsumedTab bool
+	lastLineLength       int
+}
+
+const cmcodeindent = 4
+
+var (
+	cmATXHeading    = regexp.MustCompile(`^#{1,6}(?:[ \t]+|$)`)
+	cmCodeFence     = regexp.MustCompile("^(?:`{3,}|~{3,})")
+	cmClosingFence  = regexp.MustCompile("^(?:`{3,}|~{3,})[ \t]*$")
+	cmSetextHeading = regexp.MustCompile(`^(?:=+|-+)[ \t]*$`)
+	cmThematicBreak = regexp.MustCompile(`^(?:(?:\*[ \t]*){3,}|(?:_[ \t]*){3,}|(?:-[ \t]*){3,})$`)
+	cmOrderedMarker = regexp.MustCompile(`^(\d{1,9})([.)])`)
+
+	cmHTMLBlockOpen = []*regexp.Regexp{
+		nil,
+		regexp.MustCompile(`(?i)^<(?:script|pre|textarea|style)(?:\s|>|$)`),
+		regexp.MustCompile(`^<!--`),
+		regexp.MustCompile(`^<[?]`),
+		regexp.MustCompile(`^<![A-Za-z]`),
+		regexp.MustCompile(`^<!\[CDATA\[`),
+		regexp.MustCompile(`(?i)^<[/]?(?:address|article|aside|base|basefont|blockquote|body|caption|center|col|colgroup|dd|details|dialog|dir|div|dl|dt|fieldset|figcaption|figure|footer|form|frame|frameset|h[123456]|head|header|hr|html|iframe|legend|li|link|main|menu|menuitem|nav|noframes|ol|optgroup|option|p|param|search|section|summary|table|tbody|td|tfoot|th|thead|title|tr|track|ul)(?:\s|[/]?[>]|$)`),
+		regexp.MustCompile(`(?i)^(?:` + cmOpenTag + `|` + cmCloseTag + `)\s*$`),
+	}
+	cmHTMLBlockClose = []*regexp.Regexp{
+		nil,
+		regexp.MustCompile(`(?i)</(?:script|pre|textarea|style)>`),
+		regexp.MustCompile(`-->`),
+		regexp.MustCompile(`\?>`),
+		regexp.MustCompile(`>`),
+		regexp.MustCompile(`\]\]>`),
+	}
+)
+
+/* parsecommonmark parses text as a CommonMark document */
+func (r *Renderer) parsecommonmark(text []byte) *Node {
+	p := &cmparser{
+		r:     r,
+		doc:   NewNode(Document),
+		state: map[*Node]*cmblock{},
+		refs:  map[string]linkref{},
+	}
+	p.state[p.doc] = &cmblock{open: true, pos: SourcePos{StartLine: 1, StartCol: 1}}
+	p.tip = p.doc
+
+	text = bytes.ReplaceAll(text, []byte{0}, []byte("�"))
+	lines := cmlines(text)
+	var rest [][]byte
+	for i, line := range lines {
+		if !r.step() {
+			rest = lines[i:]
+			break
+		}
+		p.incorporate(line)
+	}
+	for p.tip != nil {
+		p.finalize(p.tip, p.lineNumber)
+	}
+
+	Walk(p.doc, func(n *Node, entering bool) WalkStatus {
+		if !entering {
+			return GoToNext
+		}
+		if r.opts.SourcePos {
+			if b := p.state[n]; b != nil && n.Type != Document {
+				n.Pos = b.pos
+			}
+		}
+		if n.Type == Paragraph || n.Type == Heading {
+			p.inlines(n, p.state[n].content)
+			return SkipChildren
+		}
+		return GoToNext
+	})
+
+	/* The lines left when the budget runs out are plain text */
+	if rest != nil {
+		para := NewNode(Paragraph)
+		para.AppendChild(cmtext(bytes.Join(rest, []byte("\n"))))
+		p.doc.AppendChild(para)
+	}
+	return p.doc
+}
+
+/* cmlines splits text at line endings, a final line ending does not start
+ * another line */
+func cmlines(text []byte) [][]byte {
+	var lines [][]byte
+	for len(text) > 0 {
+		i := bytes.IndexAny(text, "\r\n")
+		if i == -1 {
+			lines = append(lines, text)
+			break
+		}
+		lines = append(lines, text[:i])
+		if text[i] == '\r' && i+1 < len(text) && text[i+1] == '\n' {
+			i++
+		}
+		text = text[i+1:]
+	}
+	return lines
+}
+
+func (p *cmparser) peek(i int) int {
+	if i < len(p.line) {
+		return int(p.line[i])
+	}
+	return -1
+}
+
+func (p *cmparser) findNextNonspace() {
+	i, cols := p.offset, p.column
+	for i < len(p.line) {
+		if p.line[i] == ' ' {
+			i++
+			cols++
+		} else if p.line[i] == '\t' {
+			i++
+			cols += 4 - cols%4
+		} else {
+			break
+		}
+	}
+	p.blank = i == len(p.line)
+	p.nextNonspace = i
+	p.nextNonspaceColumn = cols
+	p.indent = cols - p.column
+	p.indented = p.indent >= cmcodeindent
+}
+
+func (p *cmparser) advanceNextNonspace() {
+	p.offset = p.nextNonspace
+	p.column = p.nextNonspaceColumn
+	p.partiallyConsumedTab = false
+}
+
+/* advanceOffset moves count bytes, or count columns if columns is set,
+ * along the line. Tabs count up to the next tab stop. */
+func (p *cmparser) advanceOffset(count int, columns bool) {
+	for count > 0 && p.offset < len(p.line) {
+		if p.line[p.offset] == '\t' {
+			tab := 4 - p.column%4
+			if columns {
+				p.partiallyConsumedTab = tab > count
+				advance := min(tab, count)
+				p.column += advance
+				if !p.partiallyConsumedTab {
+					p.offset++
+				}
+				count -= advance
+			} else {
+				p.partiallyConsumedTab = false
+				p.column += tab
+				p.offset++
+				count--
+			}
+		} else {
+			p.partiallyConsumedTab = false
+			p.offset++
+			p.column++
+			count--
+		}
+	}
+}
+
+/* addLine adds the rest of the line to the tip */
+func (p *cmparser) addLine() {
+	b := p.state[p.tip]
+	if p.partiallyConsumedTab {
+		p.offset++
+		b.content = append(b.content, bytes.Repeat([]byte(" "), 4-p.column%4)...)
+	}
+	b.content = append(b.content, p.line[p.offset:]...)
+	b.content = append(b.content, '\n')
+}
+
+func cmcancontain(parent, child NodeType) bool {
+	switch parent {
+	case Document, Blockquote, ListItem:
+		return child != ListItem
+	case List:
+		return child == ListItem
+	}
+	return false
+}
+
+/* addChild adds a block of type t starting at offset to the tip, closing
+ * blocks that cannot contain it */
+func (p *cmparser) addChild(t NodeType, offset int) *Node {
+	for !cmcancontain(p.tip.Type, t) {
+		p.finalize(p.tip, p.lineNumber-1)
+	}
+	n := NewNode(t)
+	p.state[n] = &cmblock{open: true, pos: SourcePos{StartLine: p.lineNumber, StartCol: offset + 1},
+		depth: p.state[p.tip].depth + 1}
+	p.tip.AppendChild(n)
+	p.tip = n
+	return n
+}
+
+/* closeUnmatched closes the blocks the current line did not continue */
+func (p *cmparser) closeUnmatched() {
+	if p.allClosed {
+		return
+	}
+	for p.oldtip != p.lastMatched {
+		parent := p.oldtip.Parent
+		p.finalize(p.oldtip, p.lineNumber-1)
+		p.oldtip = parent
+	}
+	p.allClosed = true
+}
+
+/* incorporate adds a line to the document */
+func (p *cmparser) incorporate(line []byte) {
+	container := p.doc
+	p.oldtip = p.tip
+	p.offset, p.column = 0, 0
+	p.blank, p.partiallyConsumedTab = false, false
+	p.lineNumber++
+	p.line = line
+
+	/* Open blocks continue if the line starts with their markers */
+continuation:
+	for {
+		last := container.LastChild()
+		if last == nil || !p.state[last].open {
+			break
+		}
+		container = last
+		p.findNextNonspace()
+		switch p.continues(container) {
+		case 1:
+			container = container.Parent
+			break continuation
+		case 2:
+			return
+		}
+	}
+	p.allClosed = container == p.oldtip
+	p.lastMatched = container
+
+	/* Then new blocks may start */
+	matchedLeaf := container.Type != Paragraph && cmacceptslines(container.Type)
+	for !matchedLeaf {
+		p.findNextNonspace()
+		if !p.indented && (p.blank || bytes.IndexByte([]byte("#`~*+_=<>0123456789-"), p.line[p.nextNonspace]) == -1) {
+			p.advanceNextNonspace()
+			break
+		}
+		/* Too deeply nested blocks are text */
+		if !p.r.within(p.state[container].depth + 1) {
+			p.advanceNextNonspace()
+			break
+		}
+		res := 0
+		for _, start := range cmblockstarts {
+			if res = start(p, container); res != 0 {
+				break
+			}
+		}
+		if res == 0 {
+			p.advanceNextNonspace()
+			break
+		}
+		container = p.tip
+		matchedLeaf = res == 2
+	}
+
+	/* The rest of the line is text */
+	if !p.allClosed && !p.blank && p.tip.Type == Paragraph {
+		/* lazy paragraph continuation */
+		p.addLine()
+	} else {
+		p.closeUnmatched()
+		b := p.state[container]
+		switch {
+		case cmacceptslines(container.Type):
+			p.addLine()
+			if container.Type == HTMLBlock && b.htmlType >= 1 && b.htmlType <= 5 &&
+				cmHTMLBlockClose[b.htmlType].Match(p.line[p.offset:]) {
+				p.lastLineLength = len(line)
+				p.finalize(container, p.lineNumber)
+			}
+		case p.offset < len(line) && !p.blank:
+			p.addChild(Paragraph, p.offset)
+			p.advanceNextNonspace()
+			p.addLine()
+		}
+	}
+	p.lastLineLength = len(line)
+}
+
+func cmacceptslines(t NodeType) bool {
+	return t == Paragraph || t == CodeBlock || t == HTMLBlock
+}
+
+/* continues reports whether the line continues the open block n: 0 if it
+ * does, 1 if it does not and 2 if it also ended the line */
+func (p *cmparser) continues(n *Node) int {
+	b := p.state[n]
+	switch n.Type {
+	case Blockquote:
+		if p.indented || p.peek(p.nextNonspace) != '>' {
+			return 1
+		}
+		p.advanceNextNonspace()
+		p.advanceOffset(1, false)
+		if c := p.peek(p.offset); c == ' ' || c == '\t' {
+			p.advanceOffset(1, true)
+		}
+	case ListItem:
+		switch {
+		case p.blank:
+			if len(n.Children) == 0 {
+				/* A blank line after an empty item */
+				return 1
+			}
+			p.advanceNextNonspace()
+		case p.indent >= b.markerOffset+b.padding:
+			p.advanceOffset(b.markerOffset+b.padding, true)
+		default:
+			return 1
+		}
+	case Heading, HorizontalRule:
+		return 1
+	case CodeBlock:
+		if b.fenceLength > 0 {
+			rest := p.line[p.nextNonspace:]
+			if p.indent <= 3 && len(rest) > 0 && rest[0] == b.fenceChar {
+				if m := cmClosingFence.Find(rest); m != nil && len(bytes.TrimRight(m, " \t")) >= b.fenceLength {
+					p.lastLineLength = len(p.line)
+					p.finalize(n, p.lineNumber)
+					return 2
+				}
+			}
+			for i := b.fenceOffset; i > 0; i-- {
+				if c := p.peek(p.offset); c != ' ' && c != '\t' {
+					break
+				}
+				p.advanceOffset(1, true)
+			}
+		} else {
+			switch {
+			case p.indent >= cmcodeindent:
+				p.advanceOffset(cmcodeindent, true)
+			case p.blank:
+				p.advanceNextNonspace()
+			default:
+				return 1
+			}
+		}
+	case HTMLBlock:
+		if p.blank && (b.htmlType == 6 || b.htmlType == 7) {
+			return 1
+		}
+	case Paragraph:
+		if p.blank {
+			return 1
+		}
+	}
+	return 0
+}
+
+/* finalize closes block n, which ends on line */
+func (p *cmparser) finalize(n *Node, line int) {
+	b := p.state[n]
+	parent := n.Parent
+	b.open = false
+	b.pos.EndLine, b.pos.EndCol = line, p.lastLineLength
+
+	switch n.Type {
+	case Paragraph:
+		refs := false
+		for len(b.content) > 0 && b.content[0] == '[' {
+			l := p.reference(b.content)
+			if l == 0 {
+				break
+			}
+			b.content = b.content[l:]
+			refs = true
+		}
+		if refs && len(bytes.TrimSpace(b.content)) == 0 {
+			cmunlink(n)
+		}
+	case CodeBlock:
+		if b.fenceLength > 0 {
+			eol := bytes.IndexByte(b.content, '\n')
+			n.Fenced = true
+			n.Info = string(cmunescape(bytes.TrimSpace(b.content[:eol])))
+			n.Literal = b.content[eol+1:]
+		} else {
+			n.Literal = append(cmtrimblank(b.content), '\n')
+		}
+	case HTMLBlock:
+		n.Literal = cmtrimblank(b.content)
+	case List:
+		n.Tight = true
+		for i, item := range n.Children {
+			if i+1 < len(n.Children) && p.endsWithBlank(item, n.Children[i+1]) {
+				n.Tight = false
+				break
+			}
+			for j, c := range item.Children {
+				if j+1 < len(item.Children) && p.endsWithBlank(c, item.Children[j+1]) {
+					n.Tight = false
+				}
+			}
+			if !n.Tight {
+				break
+			}
+		}
+		if last := n.LastChild(); last != nil {
+			b.pos.EndLine, b.pos.EndCol = p.state[last].pos.EndLine, p.state[last].pos.EndCol
+		}
+	case ListItem:
+		if last := n.LastChild(); last != nil {
+			b.pos.EndLine, b.pos.EndCol = p.state[last].pos.EndLine, p.state[last].pos.EndCol
+		} else {
+			b.pos.EndLine, b.pos.EndCol = b.pos.StartLine, b.padding+b.markerOffset
+		}
+	}
+	p.tip = parent
+}
+
+/* cmtrimblank returns s without the trailing lines of spaces */
+func cmtrimblank(s []byte) []byte {
+	end := len(s)
+	for i := end - 1; i >= 0 && (s[i] == ' ' || s[i] == '\n'); i-- {
+		if s[i] == '\n' {
+			end = i
+		}
+	}
+	return s[:end]
+}
+
+/* endsWithBlank reports whether blank lines separate n from next */
+func (p *cmparser) endsWithBlank(n, next *Node) bool {
+	return p.state[n].pos.EndLine != p.state[next].pos.StartLine-1
+}
+
+/* cmunlink removes n from its parent */
+func cmunlink(n *Node) {
+	parent := n.Parent
+	for i := len(parent.Children) - 1; i >= 0; i-- {
+		if parent.Children[i] == n {
+			parent.Children = append(parent.Children[:i], parent.Children[i+1:]...)
+			break
+		}
+	}
+	n.Parent = nil
+}
+
+/* cmblockstarts try to start a block at the current position. They return
+ * 0 if they do not, 1 if they started a container block and 2 if they
+ * started a leaf block. */
+var cmblockstarts = []func(p *cmparser, container *Node) int{
+	(*cmparser).blockquote,
+	(*cmparser).atxheading,
+	(*cmparser).fencedcode,
+	(*cmparser).htmlblock,
+	(*cmparser).setextheading,
+	(*cmparser).thematicbreak,
+	(*cmparser).listitem,
+	(*cmparser).indentedcode,
+}
+
+func (p *cmparser) blockquote(container *Node) int {
+	if p.indented || p.peek(p.nextNonspace) != '>' {
+		return 0
+	}
+	p.advanceNextNonspace()
+	p.advanceOffset(1, false)
+	if c := p.peek(p.offset); c == ' ' || c == '\t' {
+		p.advanceOffset(1, true)
+	}
+	p.closeUnmatched()
+	p.addChild(Blockquote, p.nextNonspace)
+	return 1
+}
+
+func (p *cmparser) atxheading(container *Node) int {
+	if p.indented {
+		return 0
+	}
+	m := cmATXHeading.Find(p.line[p.nextNonspace:])
+	if m == nil {
+		return 0
+	}
+	p.advanceNextNonspace()
+	p.advanceOffset(len(m), false)
+	p.closeUnmatched()
+	n := p.addChild(Heading, p.nextNonspace)
+	n.Level = len(bytes.TrimSpace(m))
+
+	/* The closing sequence is optional */
+	content := p.line[p.offset:]
+	if len(bytes.Trim(bytes.Trim(content, " \t"), "#")) == 0 {
+		content = nil
+	} else {
+		trimmed := bytes.TrimRight(content, " \t")
+		if closing := bytes.TrimRight(trimmed, "#"); len(closing) < len(trimmed) &&
+			len(closing) > 0 && (closing[len(closing)-1] == ' ' || closing[len(closing)-1] == '\t') {
+			content = bytes.TrimRight(closing, " \t")
+		}
+	}
+	p.state[n].content = append([]byte(nil), content...)
+	p.advanceOffset(len(p.line)-p.offset, false)
+	return 2
+}
+
+func (p *cmparser) fencedcode(container *Node) int {
+	if p.indented {
+		return 0
+	}
+	rest := p.line[p.nextNonspace:]
+	m := cmCodeFence.Find(rest)
+	if m == nil || (m[0] == '`' && bytes.IndexByte(rest[len(m):], '`') != -1) {
+		return 0
+	}
+	p.closeUnmatched()
+	n := p.addChild(CodeBlock, p.nextNonspace)
+	b := p.state[n]
+	b.fenceLength = len(m)
+	b.fenceChar = m[0]
+	b.fenceOffset = p.indent
+	p.advanceNextNonspace()
+	p.advanceOffset(len(m), false)
+	return 2
+}
+
+func (p *cmparser) htmlblock(container *Node) int {
+	if p.indented || p.peek(p.nextNonspace) != '<' || p.r.opts.NoHTML {
+		return 0
+	}
+	s := p.line[p.nextNonspace:]
+	for t := 1; t <= 7; t++ {
+		if !cmHTMLBlockOpen[t].Match(s) {
+			continue
+		}
+		/* Other tags do not interrupt paragraphs */
+		if t == 7 && (container.Type == Paragraph || !p.allClosed && !p.blank && p.tip.Type == Paragraph) {
+			continue
+		}
+		p.closeUnmatched()
+		/* Spaces in front of the tag belong to the block */
+		n := p.addChild(HTMLBlock, p.offset)
+		p.state[n].htmlType = t
+		return 2
+	}
+	return 0
+}
+
+func (p *cmparser) setextheading(container *Node) int {
+	if p.indented || container.Type != Paragraph {
+		return 0
+	}
+	m := cmSetextHeading.Find(p.line[p.nextNonspace:])
+	if m == nil {
+		return 0
+	}
+	p.closeUnmatched()
+	b := p.state[container]
+	for len(b.content) > 0 && b.content[0] == '[' {
+		l := p.reference(b.content)
+		if l == 0 {
+			break
+		}
+		b.content = b.content[l:]
+	}
+	if len(b.content) == 0 {
+		return 0
+	}
+	container.Type = Heading
+	container.Level = 2
+	if m[0] == '=' {
+		container.Level = 1
+	}
+	p.tip = container
+	p.advanceOffset(len(p.line)-p.offset, false)
+	return 2
+}
+
+func (p *cmparser) thematicbreak(container *Node) int {
+	if p.indented || !cmThematicBreak.Match(p.line[p.nextNonspace:]) {
+		return 0
+	}
+	p.closeUnmatched()
+	p.addChild(HorizontalRule, p.nextNonspace)
+	p.advanceOffset(len(p.line)-p.offset, false)
+	return 2
+}
+
+func (p *cmparser) listitem(container *Node) int {
+	if p.indented && container.Type != List {
+		return 0
+	}
+	data := p.listmarker(container)
+	if data == nil {
+		return 0
+	}
+	p.closeUnmatched()
+	if t := p.state[p.tip]; p.tip.Type != List || t.bullet != data.bullet || t.delim != data.delim {
+		list := p.addChild(List, p.nextNonspace)
+		list.Ordered = data.bullet == 0
+		list.Start = data.start
+		b := p.state[list]
+		b.bullet, b.delim = data.bullet, data.delim
+	}
+	item := p.addChild(ListItem, p.nextNonspace)
+	b := p.state[item]
+	b.bullet, b.delim = data.bullet, data.delim
+	b.padding, b.markerOffset = data.padding, data.markerOffset
+	return 1
+}
+
+type cmlistmarker struct {
+	bullet, delim         byte
+	start                 int
+	padding, markerOffset int
+}
+
+/* listmarker reads the list marker at the current position and moves past
+ * it and the spaces following it */
+func (p *cmparser) listmarker(container *Node) *cmlistmarker {
+	if p.indent >= 4 {
+		return nil
+	}
+	data := &cmlistmarker{markerOffset: p.indent}
+	rest := p.line[p.nextNonspace:]
+	l := 0
+	if c := rest[0]; c == '*' || c == '+' || c == '-' {
+		data.bullet = c
+		l = 1
+	} else if m := cmOrderedMarker.FindSubmatch(rest); m != nil &&
+		(container.Type != Paragraph || string(bytes.TrimLeft(m[1], "0")) == "1") {
+		for _, d := range m[1] {
+			data.start = data.start*10 + int(d-'0')
+		}
+		data.delim = m[2][0]
+		l = len(m[0])
+	} else {
+		return nil
+	}
+
+	/* The marker is followed by a space, or ends the line */
+	if c := p.peek(p.nextNonspace + l); c != -1 && c != ' ' && c != '\t' {
+		return nil
+	}
+	/* Empty items do not interrupt paragraphs */
+	if container.Type == Paragraph && len(bytes.Trim(rest[l:], " \t")) == 0 {
+		return nil
+	}
+
+	p.advanceNextNonspace()
+	p.advanceOffset(l, true)
+	startCol, startOffset := p.column, p.offset
+	for {
+		p.advanceOffset(1, true)
+		if c := p.peek(p.offset); p.column-startCol >= 5 || (c != ' ' && c != '\t') {
+			break
+		}
+	}
+	blankItem := p.peek(p.offset) == -1
+	spaces := p.column - startCol
+	if spaces >= 5 || spaces < 1 || blankItem {
+		/* Indented code starts after a single space */
+		data.padding = l + 1
+		p.column, p.offset = startCol, startOffset
+		if c := p.peek(p.offset); c == ' ' || c == '\t' {
+			p.advanceOffset(1, true)
+		}
+	} else {
+		data.padding = l + spaces
+	}
+	return data
+}
+
+func (p *cmparser) indentedcode(container *Node) int {
+	if !p.indented || p.tip.Type == Paragraph || p.blank {
+		return 0
+	}
+	p.advanceOffset(cmcodeindent, true)
+	p.closeUnmatched()
+	p.addChild(CodeBlock, p.offset)
+	return 2
+}
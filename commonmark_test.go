@@ -0,0 +1,53 @@
+package smu
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+type specExample struct {
+	Markdown string `json:"markdown"`
+	HTML     string `json:"html"`
+	Example  int    `json:"example"`
+	Section  string `json:"section"`
+}
+
+func TestCommonMarkSpec(t *testing.T) {
+	data, err := os.ReadFile("testdata/spec.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var examples []specExample
+	if err := json.Unmarshal(data, &examples); err != nil {
+		t.Fatal(err)
+	}
+	r := New(Options{CommonMark: true})
+	for _, e := range examples {
+		if got := string(r.Process([]byte(e.Markdown))); got != e.HTML {
+			t.Errorf("example %d (%s):\ninput:\n%q\ngot:\n%q\nwant:\n%q",
+				e.Example, e.Section, e.Markdown, got, e.HTML)
+		}
+	}
+}
+
+// TestCommonMarkAlt checks that raw HTML in the description of an image is
+// escaped in its alt attribute, so it cannot end the attribute.
+func TestCommonMarkAlt(t *testing.T) {
+	tests := []struct {
+		opts       Options
+		text, want string
+	}{
+		{Options{CommonMark: true, Safe: true},
+			"![<a title=\" onerror=alert(1) x=\">](y.png)",
+			"<p><img src=\"y.png\" alt=\"&lt;a title=&quot; onerror=alert(1) x=&quot;&gt;\" /></p>\n"},
+		{Options{CommonMark: true},
+			"![a <b>c</b>](y.png)",
+			"<p><img src=\"y.png\" alt=\"a &lt;b&gt;c&lt;/b&gt;\" /></p>\n"},
+	}
+	for _, tt := range tests {
+		if got := string(New(tt.opts).Process([]byte(tt.text))); got != tt.want {
+			t.Errorf("%q:\ngot  %q\nwant %q", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package smu
+
+import "testing"
+
+func TestDefinitionLists(t *testing.T) {
+	tests := []struct{ text, want string }{
+		{"Term\n: def\n", "<dl>\n<dt>Term</dt>\n<dd>def</dd>\n</dl>\n"},
+		{"*em* term\n:   `code`\n", "<dl>\n<dt><em>em</em> term</dt>\n<dd><code>code</code></dd>\n</dl>\n"},
+
+		/* Terms may have several definitions and share them */
+		{"Term\n: one\n: two\n", "<dl>\n<dt>Term</dt>\n<dd>one</dd>\n<dd>two</dd>\n</dl>\n"},
+		{"A\nB\n: both\n", "<dl>\n<dt>A</dt>\n<dt>B</dt>\n<dd>both</dd>\n</dl>\n"},
+		{"T1\n: d1\n\nT2\n: d2\n", "<dl>\n<dt>T1</dt>\n<dd>d1</dd>\n<dt>T2</dt>\n<dd>d2</dd>\n</dl>\n"},
+
+		/* Blank lines before a definition make it a block */
+		{"Term\n\n: loose\n", "<dl>\n<dt>Term</dt>\n<dd><p>loose</p>\n</dd>\n</dl>\n"},
+		{"Term\n: para one\n\n    para two\n\n    ```\n    code\n    ```\n\n    - x\n    - y\n",
+			"<dl>\n<dt>Term</dt>\n<dd><p>para one</p>\n<p>para two</p>\n<pre><code>code\n</code></pre>\n<ul>\n<li>x</li>\n<li>y</li>\n</ul>\n</dd>\n</dl>\n"},
+
+		/* Where definitions end */
+		{"Term\n: def\nlazy\n", "<dl>\n<dt>Term</dt>\n<dd>def\nlazy</dd>\n</dl>\n"},
+		{"Term\n: def\n\nParagraph\n", "<dl>\n<dt>Term</dt>\n<dd>def</dd>\n</dl>\n<p>Paragraph</p>\n"},
+		{"a\n:b\n", "<p>a\n:b</p>\n"},
+	}
+	for _, tt := range tests {
+		if got := string(New(Options{}).Process([]byte(tt.text))); got != tt.want {
+			t.Errorf("%q:\ngot  %q\nwant %q", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package smu
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+/* Most positions of a document are plain text no parser matches. Inside
+ * a block, process therefore only runs the parsers whose markup can start
+ * with the byte at the position, and adds the text up to the next such
+ * byte at once. Blocks start once per line at most, so all parsers run at
+ * the start of a block. */
+
+/* dispatch has the parsers that may match inside a block by the first
+ * byte of the text */
+type dispatch [256][]Parser
+
+/* blockonly is the start bytes of parsers that only match at the start of
+ * a block */
+func blockonly(*Renderer) string {
+	return ""
+}
+
+/* startswith returns the start bytes of a parser matching markup that
+ * starts with one of the bytes of s */
+func startswith(s string) func(*Renderer) string {
+	return func(*Renderer) string { return s }
+}
+
+func (r *Renderer) surroundstarts() string {
+	var s strings.Builder
+	for _, t := range r.surrounds {
+		if t.search != "" {
+			s.WriteByte(t.search[0])
+		}
+	}
+	return s.String()
+}
+
+func (r *Renderer) autolinkstarts() string {
+	if !r.opts.Autolink {
+		return ""
+	}
+	return mailBytes
+}
+
+/* inlineparsers returns the dispatch table of r, which is built again
+ * after parsers or surrounds were added or removed */
+func (r *Renderer) inlineparsers() *dispatch {
+	if r.dispatch != nil {
+		return r.dispatch
+	}
+	d := new(dispatch)
+	starts := make([]string, len(r.parsers))
+	size := 0
+	for i, p := range r.parsers {
+		if p.starts == nil {
+			size += len(d)
+		} else {
+			starts[i] = p.starts(r)
+			size += len(starts[i])
+		}
+	}
+
+	/* The lists of all bytes share one array */
+	all := make([]Parser, 0, size)
+	for c := range d {
+		from := len(all)
+		for i, p := range r.parsers {
+			if p.starts == nil || strings.IndexByte(starts[i], byte(c)) != -1 {
+				all = append(all, p.parse)
+			}
+		}
+		d[c] = all[from:len(all):len(all)]
+	}
+	r.dispatch = d
+	return d
+}
+
+/* plaintext returns the length of the text up to the next line or byte a
+ * parser may match, skipping the first rune, which no parser matched */
+func (d *dispatch) plaintext(text []byte) int {
+	_, p := utf8.DecodeRune(text)
+	for p < len(text) && text[p] != '\n' && len(d[text[p]]) == 0 {
+		if text[p] < utf8.RuneSelf {
+			p++
+		} else {
+			_, size := utf8.DecodeRune(text[p:])
+			p += size
+		}
+	}
+	return p
+}
@@ -0,0 +1,41 @@
+package smu
+
+import "testing"
+
+func TestExtensions(t *testing.T) {
+	all := Options{Strikethrough: true, Mark: true, Superscript: true, Subscript: true}
+	tests := []struct {
+		opts       Options
+		text, want string
+	}{
+		{all, "~~a b~~ ==c d== x^2^ H~2~O",
+			"<p><del>a b</del> <mark>c d</mark> x<sup>2</sup> H<sub>2</sub>O</p>\n"},
+		{all, "~~a~b~~ ~~*a*~~ ==`a`==",
+			"<p><del>a~b</del> <del><em>a</em></del> <mark><code>a</code></mark></p>\n"},
+		{all, "~~a\nb~~ ~~a", "<p><del>a\nb</del> ~~a</p>\n"},
+		{all, "`~~a~~`", "<p><code>~~a~~</code></p>\n"},
+
+		/* Each is enabled on its own */
+		{Options{}, "~~a~~ ==b== ^c^ ~d~", "<p>~~a~~ ==b== ^c^ ~d~</p>\n"},
+		{Options{Strikethrough: true}, "~~a~~ ==b== ^c^ ~d~", "<p><del>a</del> ==b== ^c^ ~d~</p>\n"},
+		{Options{Mark: true}, "~~a~~ ==b== ^c^ ~d~", "<p>~~a~~ <mark>b</mark> ^c^ ~d~</p>\n"},
+		{Options{Superscript: true}, "~~a~~ ==b== ^c^ ~d~", "<p>~~a~~ ==b== <sup>c</sup> ~d~</p>\n"},
+		{Options{Subscript: true}, "~~a~~ ==b== ^c^ ~d~", "<p>~~a~~ ==b== ^c^ <sub>d</sub></p>\n"},
+
+		/* Superscripts and subscripts are single words out of runs */
+		{all, "a^b c^ a~b c~", "<p>a^b c^ a~b c~</p>\n"},
+		{all, "^^ x^2^^ ~a~~", "<p>^^ x^2^^ ~a~~</p>\n"},
+		{Options{Subscript: true}, "~~a~b~~", "<p>~~a~b~~</p>\n"},
+
+		/* Escaped delimiters are text */
+		{all, `\~~a~~ \~a~ \^a^ \==a==`, "<p>~~a~~ ~a~ ^a^ ==a==</p>\n"},
+		{all, `~~a\~~ ~a\~ ^a\^ ==a\==`, "<p>~~a~~ ~a~ ^a^ ==a==</p>\n"},
+		{all, `~~a\~~b~~ ^a\^b^`, "<p><del>a~~b</del> <sup>a^b</sup></p>\n"},
+		{Options{}, `\~ \^ \=`, "<p>~ ^ =</p>\n"},
+	}
+	for _, tt := range tests {
+		if got := string(New(tt.opts).Process([]byte(tt.text))); got != tt.want {
+			t.Errorf("%+v %q:\ngot  %q\nwant %q", tt.opts, tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package smu
+
+import "testing"
+
+func TestCodeFence(t *testing.T) {
+	tests := []struct{ text, want string }{
+		{"~~~\na\n~~~\n", "<pre><code>a\n</code></pre>\n"},
+		{"````md\n```\nx\n```\n````\n",
+			"<pre><code class=\"language-md\">\n```\nx\n```\n</code></pre>\n"},
+		{"~~~\na\n```\n~~~\n", "<pre><code>a\n```\n</code></pre>\n"},
+
+		/* The closing fence is at least as long and has no info */
+		{"```\na\n``\nb\n````\nc\n", "<pre><code>a\n``\nb\n</code></pre>\n<p>c</p>\n"},
+		{"```` a\n```` b\n", "<pre><code class=\"language-a\">\n```` b\n</code></pre>\n"},
+		{"```\na\n", "<pre><code>a\n</code></pre>\n"},
+
+		/* The indentation of the fence is removed from the lines */
+		{"  ```\n  a\n b\nc\n  ```\n", "<pre><code>a\nb\nc\n</code></pre>\n"},
+		{"- a\n\n  ```\n  b\n  ```\n", "<ul>\n<li><p>a</p>\n<pre><code>b\n</code></pre>\n</li>\n</ul>\n"},
+		{"    ```\n    a\n    ```\n", "<pre><code>```\na\n```\n\n</code></pre>\n"},
+
+		/* Tilde fences may have backticks in the info, two backticks are no fence */
+		{"~~~ a`b\nc\n~~~\n", "<pre><code class=\"language-a`b\">\nc\n</code></pre>\n"},
+		{"``\na\n``\n", "<p><code>\na\n</code></p>\n"},
+		{"a\n```\nb\n```\n", "<p>a</p>\n<pre><code>b\n</code></pre>\n"},
+
+		/* Fences in the middle of a line are text */
+		{"a ~~~ b", "<p>a ~~~ b</p>\n"},
+		{"a ~~~ b\nc ``` d\n", "<p>a ~~~ b\nc <code></code>` d</p>\n"},
+		{"I  ```go", "<p>I  <code></code>`go</p>\n"},
+	}
+	for _, tt := range tests {
+		if got := string(New(Options{}).Process([]byte(tt.text))); got != tt.want {
+			t.Errorf("%q:\ngot  %q\nwant %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestCodeInfo(t *testing.T) {
+	tests := []struct{ info, want string }{
+		{"go {.class #id linenos=true hl_lines=\"2-4\"}",
+			"<pre class=\"class\" id=\"id\" data-linenos=\"true\" data-hl_lines=\"2-4\"><code class=\"language-go\">\n"},
+		{"go {.a .b onclick=x on<x=y}", "<pre class=\"a b\" data-onclick=\"x\"><code class=\"language-go\">\n"},
+		{"go {.a", "<pre><code class=\"language-go {.a\">\n"},
+		{"{.a}", "<pre class=\"a\"><code>"},
+	}
+	for _, tt := range tests {
+		text := "```" + tt.info + "\nx\n```\n"
+		if got := string(New(Options{}).Process([]byte(text))); got != tt.want+"x\n</code></pre>\n" {
+			t.Errorf("%q:\ngot  %q\nwant %q", tt.info, got, tt.want+"x\n</code></pre>\n")
+		}
+	}
+}
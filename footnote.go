@@ -0,0 +1,123 @@
+package smu
+
+import (
+	"bytes"
+	"strings"
+)
+
+/* footnote is a footnote definition found before parsing */
+type footnote struct {
+	body srccopy
+	node *Node /* Footnote node, set once the footnote is referenced */
+}
+
+/* footnotedef reads the footnote definition "[^label]: text" starting at p.
+ * Further lines belong to the definition if they are indented. It returns
+ * the normalised label, the unindented text and the end of the definition,
+ * which is 0 if there is none. */
+func footnotedef(text []byte, p int) (string, srccopy, int) {
+	var body srccopy
+	for i := 0; i < 3 && p < len(text) && text[p] == ' '; i++ {
+		p++
+	}
+	if !bytes.HasPrefix(text[p:], []byte("[^")) {
+		return "", body, 0
+	}
+	stop := bytes.IndexByte(text[p:], ']')
+	if stop == -1 || p+stop+1 >= len(text) || text[p+stop+1] != ':' {
+		return "", body, 0
+	}
+	label, ok := footnotelabel(text[p+2 : p+stop])
+	if !ok {
+		return "", body, 0
+	}
+	p += stop + 2
+	for p < len(text) && isSpace(text[p]) {
+		p++
+	}
+	eol := lineend(text, p)
+	body.add(text[p:eol])
+
+	/* Blank lines belong to the definition if an indented line follows */
+	for p = eol; p < len(text); {
+		q := p
+		for q < len(text) && len(bytes.TrimSpace(text[q:lineend(text, q)])) == 0 {
+			q = lineend(text, q)
+		}
+		if q == len(text) {
+			break
+		}
+		indent := 0
+		if text[q] == '\t' {
+			indent = 1
+		} else if bytes.HasPrefix(text[q:], []byte("    ")) {
+			indent = 4
+		} else {
+			break
+		}
+		body.buf = append(body.buf, bytes.Repeat([]byte("\n"), bytes.Count(text[p:q], []byte("\n")))...)
+		eol = lineend(text, q)
+		body.add(text[q+indent : eol])
+		p = eol
+	}
+	return label, body, p
+}
+
+/* footnotelabel normalises a footnote label, which must not be empty or
+ * contain white space */
+func footnotelabel(label []byte) (string, bool) {
+	if len(label) == 0 || bytes.ContainsAny(label, " \t\n[") {
+		return "", false
+	}
+	return strings.ToLower(string(label)), true
+}
+
+func (r *Renderer) dofootnote(text []byte, newblock bool) int {
+	if !hasprefix(text, "[^") {
+		return 0
+	}
+	stop := r.index(text, "]")
+	if stop == -1 {
+		return 0
+	}
+	label, ok := footnotelabel(text[2:stop])
+	f := r.footnotes[label]
+	if !ok || f == nil {
+		return 0
+	}
+
+	if f.node == nil {
+		f.node = NewNode(Footnote)
+		f.node.Label = label
+		f.node.Index = len(r.fnlist) + 1
+		r.fnlist = append(r.fnlist, f.node)
+	}
+	f.node.Refs++
+	n := r.AddNode(NewNode(FootnoteRef))
+	n.Label = label
+	n.Index = f.node.Index
+	n.Refs = f.node.Refs
+	return stop + 1
+}
+
+/* addfootnotes parses the referenced footnotes into a section at the end
+ * of doc, in the order of their first reference. Their bodies are parts of
+ * the source text. */
+func (r *Renderer) addfootnotes(doc *Node, text []byte) {
+	if len(r.fnlist) == 0 {
+		return
+	}
+	section := NewNode(Footnotes)
+	doc.AppendChild(section)
+	if r.src != nil {
+		r.src = newsrcmap(text)
+	}
+	/* Footnotes may reference further footnotes */
+	for i := 0; i < len(r.fnlist); i++ {
+		n := r.fnlist[i]
+		section.AppendChild(n)
+		r.EndParagraph()
+		f := r.footnotes[n.Label]
+		r.parsecopy(n, f.body, true)
+	}
+}
@@ -0,0 +1,43 @@
+package smu
+
+import "testing"
+
+/* footref and footbackref are the html of the reference and back-link of
+ * footnote 1 */
+const (
+	footref     = "<sup class=\"footnote-ref\"><a href=\"#fn-1\" id=\"fnref-1\">1</a></sup>"
+	footbackref = "<a href=\"#fnref-1\" class=\"footnote-backref\">&#8617;</a>"
+)
+
+func TestFootnotes(t *testing.T) {
+	tests := []struct{ text, want string }{
+		{"a[^1]\n\n[^1]: note\n",
+			"<p>a" + footref + "</p>\n<section class=\"footnotes\">\n<ol>\n<li id=\"fn-1\">\n<p>note " + footbackref + "</p>\n</li>\n</ol>\n</section>\n"},
+
+		/* Every reference has a back-link */
+		{"a[^1] b[^1]\n\n[^1]: note\n",
+			"<p>a" + footref + " b<sup class=\"footnote-ref\"><a href=\"#fn-1\" id=\"fnref-1-2\">1</a></sup></p>\n<section class=\"footnotes\">\n<ol>\n<li id=\"fn-1\">\n<p>note " + footbackref + " <a href=\"#fnref-1-2\" class=\"footnote-backref\">&#8617;</a></p>\n</li>\n</ol>\n</section>\n"},
+
+		/* Footnotes are numbered in the order of their first reference,
+		 * labels are case-insensitive and the first definition is used */
+		{"a[^B] b[^a]\n\n[^a]: one\n[^b]: two\n[^A]: three\n",
+			"<p>a" + footref + " b<sup class=\"footnote-ref\"><a href=\"#fn-2\" id=\"fnref-2\">2</a></sup></p>\n<section class=\"footnotes\">\n<ol>\n<li id=\"fn-1\">\n<p>two " + footbackref + "</p>\n</li>\n<li id=\"fn-2\">\n<p>one <a href=\"#fnref-2\" class=\"footnote-backref\">&#8617;</a></p>\n</li>\n</ol>\n</section>\n"},
+
+		/* Indented lines continue the definition */
+		{"a[^n]\n\n[^n]: para one\n\n    para two\n\n    ```\n    code\n    ```\nafter\n",
+			"<p>a" + footref + "</p>\n<p>after</p>\n<section class=\"footnotes\">\n<ol>\n<li id=\"fn-1\">\n<p>para one</p>\n<p>para two</p>\n<pre><code>code\n</code></pre>\n<p>" + footbackref + "</p>\n</li>\n</ol>\n</section>\n"},
+		{"a[^n]\n\n[^n]: one\n\n    two\n",
+			"<p>a" + footref + "</p>\n<section class=\"footnotes\">\n<ol>\n<li id=\"fn-1\">\n<p>one</p>\n<p>two " + footbackref + "</p>\n</li>\n</ol>\n</section>\n"},
+
+		/* Undefined references are text, unreferenced definitions go */
+		{"a[^x] b\n", "<p>a[^x] b</p>\n"},
+		{"[^n]: unused\n\ntext\n", "<p>text</p>\n"},
+		{"a[^ b]\n\n[^ b]: x\n", "<p>a[^ b]</p>\n<p>[^ b]: x</p>\n"},
+		{"`[^n]`\n\n    [^n]: in code\n", "<p><code>[^n]</code></p>\n<pre><code>[^n]: in code\n\n</code></pre>\n"},
+	}
+	for _, tt := range tests {
+		if got := string(New(Options{}).Process([]byte(tt.text))); got != tt.want {
+			t.Errorf("%q:\ngot  %q\nwant %q", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,444 @@
+package smu
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FrontMatter splits the front matter off text and parses it. Front matter
+// is YAML between lines "---" or TOML between lines "+++" at the very start
+// of text. Only the commonly used subsets of both are understood: scalars,
+// lists and nested maps or tables. FrontMatter returns a nil map and text
+// unchanged if there is no front matter.
+func FrontMatter(text []byte) (meta map[string]any, body []byte, err error) {
+	var delim string
+	switch {
+	case bytes.HasPrefix(text, []byte("---\n")), bytes.HasPrefix(text, []byte("---\r\n")):
+		delim = "---"
+	case bytes.HasPrefix(text, []byte("+++\n")), bytes.HasPrefix(text, []byte("+++\r\n")):
+		delim = "+++"
+	default:
+		return nil, text, nil
+	}
+
+	start := lineend(text, 0)
+	stop, next := -1, 0
+	for p := start; p < len(text); p = lineend(text, p) {
+		eol := lineend(text, p)
+		line := bytes.TrimRight(text[p:eol], "\r\n")
+		if string(line) == delim || (delim == "---" && string(line) == "...") {
+			stop, next = p, eol
+			break
+		}
+	}
+	if stop == -1 {
+		return nil, text, nil
+	}
+
+	var lines []fmline
+	for n, line := range strings.Split(string(text[start:stop]), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if t := strings.TrimSpace(line); t == "" || t[0] == '#' {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		lines = append(lines, fmline{n + 2, indent, line[indent:]})
+	}
+	if delim == "---" {
+		meta, err = parseyaml(lines)
+	} else {
+		meta, err = parsetoml(lines)
+	}
+	if err != nil {
+		return nil, text, err
+	}
+	return meta, text[next:], nil
+}
+
+/* fmline is a line of front matter without comments and blank lines */
+type fmline struct {
+	n      int /* line number in the document */
+	indent int
+	text   string
+}
+
+func fmerror(l fmline, format string, a ...any) error {
+	return fmt.Errorf("front matter line %d: "+format, append([]any{l.n}, a...)...)
+}
+
+func parseyaml(lines []fmline) (map[string]any, error) {
+	if len(lines) == 0 {
+		return map[string]any{}, nil
+	}
+	v, i, err := yamlblock(lines, 0, lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if i < len(lines) {
+		return nil, fmerror(lines[i], "unexpected indentation")
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmerror(lines[0], "expected key: value")
+	}
+	return m, nil
+}
+
+/* yamlblock parses the map or list at indent starting with line i and
+ * returns it with the index of the first line after it */
+func yamlblock(lines []fmline, i, indent int) (any, int, error) {
+	if lines[i].text == "-" || strings.HasPrefix(lines[i].text, "- ") {
+		var list []any
+		for i < len(lines) && lines[i].indent == indent &&
+			(lines[i].text == "-" || strings.HasPrefix(lines[i].text, "- ")) {
+			l := lines[i]
+			item := strings.TrimLeft(strings.TrimPrefix(l.text, "-"), " ")
+			switch {
+			case item == "":
+				/* the item is the block below */
+				if i+1 == len(lines) || lines[i+1].indent <= indent {
+					list = append(list, nil)
+					i++
+					continue
+				}
+				v, next, err := yamlblock(lines, i+1, lines[i+1].indent)
+				if err != nil {
+					return nil, 0, err
+				}
+				list, i = append(list, v), next
+			case yamlkey(item) != -1:
+				/* "- key: value" starts a map item at the column of key */
+				sub := append([]fmline{{l.n, l.indent + len(l.text) - len(item), item}}, lines[i+1:]...)
+				v, next, err := yamlblock(sub, 0, sub[0].indent)
+				if err != nil {
+					return nil, 0, err
+				}
+				list, i = append(list, v), i+next
+			default:
+				v, err := yamlscalar(item)
+				if err != nil {
+					return nil, 0, fmerror(l, "%v", err)
+				}
+				list, i = append(list, v), i+1
+			}
+		}
+		return list, i, nil
+	}
+
+	m := map[string]any{}
+	for i < len(lines) && lines[i].indent == indent {
+		l := lines[i]
+		colon := yamlkey(l.text)
+		if colon == -1 {
+			return nil, 0, fmerror(l, "expected key: value")
+		}
+		key := l.text[:colon]
+		if k, err := yamlscalar(key); err == nil {
+			if s, ok := k.(string); ok {
+				key = s
+			}
+		}
+		value := strings.TrimSpace(stripcomment(l.text[colon+1:]))
+		i++
+		switch {
+		case value == "|" || value == ">":
+			/* block scalars keep the lines below, folded ones joined
+			 * by spaces */
+			var block []string
+			for base := i; i < len(lines) && lines[i].indent > indent; i++ {
+				pad := max(lines[i].indent-lines[base].indent, 0)
+				block = append(block, strings.Repeat(" ", pad)+lines[i].text)
+			}
+			sep := "\n"
+			if value == ">" {
+				sep = " "
+			}
+			m[key] = strings.Join(block, sep)
+		case value != "":
+			v, err := yamlscalar(value)
+			if err != nil {
+				return nil, 0, fmerror(l, "%v", err)
+			}
+			m[key] = v
+		case i < len(lines) && (lines[i].indent > indent ||
+			(lines[i].indent == indent && strings.HasPrefix(lines[i].text, "-"))):
+			/* Lists may be at the indentation of their key */
+			v, next, err := yamlblock(lines, i, lines[i].indent)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key], i = v, next
+		default:
+			m[key] = nil
+		}
+	}
+	return m, i, nil
+}
+
+/* yamlkey returns the position of the colon after the key of line, or -1 */
+func yamlkey(line string) int {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' '):
+			return -1
+		case c == ':' && (i+1 == len(line) || line[i+1] == ' '):
+			if i == 0 {
+				return -1
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+/* stripcomment removes a comment outside of quotes from value */
+func stripcomment(value string) string {
+	quote := byte(0)
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || value[i-1] == ' ' || value[i-1] == '\t'):
+			return value[:i]
+		}
+	}
+	return value
+}
+
+/* yamlscalar parses a plain, quoted or flow list value */
+func yamlscalar(s string) (any, error) {
+	s = strings.TrimSpace(stripcomment(s))
+	switch {
+	case s == "", s == "~", s == "null", s == "Null", s == "NULL":
+		return nil, nil
+	case s[0] == '"':
+		return unquote(s)
+	case s[0] == '\'':
+		if len(s) < 2 || s[len(s)-1] != '\'' {
+			return nil, fmt.Errorf("unterminated string %s", s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	case s[0] == '[':
+		if s[len(s)-1] != ']' {
+			return nil, fmt.Errorf("unterminated list %s", s)
+		}
+		list := []any{}
+		for _, item := range splitlist(s[1 : len(s)-1]) {
+			v, err := yamlscalar(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	}
+	switch s {
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+	return number(s), nil
+}
+
+/* unquote parses the double-quoted string s */
+func unquote(s string) (any, error) {
+	u, err := strconv.Unquote(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid string %s", s)
+	}
+	return u, nil
+}
+
+/* number returns s as an int64 or float64 if it is one, else s */
+func number(s string) any {
+	if t := strings.TrimLeft(s, "+-"); t == "" || !isDigit(t[0]) {
+		return s
+	}
+	if i, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	return s
+}
+
+/* splitlist splits the items of a flow list or array at commas outside
+ * quotes and brackets */
+func splitlist(s string) []string {
+	var items []string
+	quote := byte(0)
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case c == ',' && depth == 0:
+			items = append(items, s[start:i])
+			start = i + 1
+		}
+	}
+	if strings.TrimSpace(s[start:]) != "" {
+		items = append(items, s[start:])
+	}
+	return items
+}
+
+func parsetoml(lines []fmline) (map[string]any, error) {
+	meta := map[string]any{}
+	table := meta
+	for i := 0; i < len(lines); i++ {
+		l := lines[i]
+		text := strings.TrimSpace(stripcomment(l.text))
+		if strings.HasPrefix(text, "[") {
+			if strings.HasPrefix(text, "[[") || !strings.HasSuffix(text, "]") {
+				return nil, fmerror(l, "unsupported table %s", text)
+			}
+			var err error
+			if table, err = tomltable(meta, text[1:len(text)-1]); err != nil {
+				return nil, fmerror(l, "%v", err)
+			}
+			continue
+		}
+
+		eq := strings.IndexByte(text, '=')
+		if eq == -1 {
+			return nil, fmerror(l, "expected key = value")
+		}
+		value := strings.TrimSpace(text[eq+1:])
+		/* Arrays may span lines */
+		for strings.HasPrefix(value, "[") && strings.Count(value, "[") > strings.Count(value, "]") && i+1 < len(lines) {
+			i++
+			value += " " + strings.TrimSpace(stripcomment(lines[i].text))
+		}
+		v, err := tomlvalue(value)
+		if err != nil {
+			return nil, fmerror(l, "%v", err)
+		}
+		keys := tomlkeys(text[:eq])
+		t, err := tomltable(table, strings.Join(keys[:len(keys)-1], "."))
+		if err != nil {
+			return nil, fmerror(l, "%v", err)
+		}
+		t[keys[len(keys)-1]] = v
+	}
+	return meta, nil
+}
+
+/* tomlkeys splits a dotted key into its parts */
+func tomlkeys(key string) []string {
+	var keys []string
+	for _, k := range splitdots(key) {
+		k = strings.TrimSpace(k)
+		if u, err := strconv.Unquote(k); err == nil {
+			k = u
+		} else if len(k) >= 2 && k[0] == '\'' && k[len(k)-1] == '\'' {
+			k = k[1 : len(k)-1]
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+/* splitdots splits key at dots outside quotes */
+func splitdots(key string) []string {
+	var parts []string
+	quote := byte(0)
+	start := 0
+	for i := 0; i < len(key); i++ {
+		switch c := key[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '.':
+			parts = append(parts, key[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, key[start:])
+}
+
+/* tomltable returns the table at the dotted path below m, creating it */
+func tomltable(m map[string]any, path string) (map[string]any, error) {
+	if strings.TrimSpace(path) == "" {
+		return m, nil
+	}
+	for _, k := range tomlkeys(path) {
+		switch v := m[k].(type) {
+		case nil:
+			t := map[string]any{}
+			m[k] = t
+			m = t
+		case map[string]any:
+			m = v
+		default:
+			return nil, fmt.Errorf("%s is not a table", k)
+		}
+	}
+	return m, nil
+}
+
+/* tomlvalue parses a string, number, boolean, date or array */
+func tomlvalue(s string) (any, error) {
+	switch {
+	case s == "":
+		return nil, fmt.Errorf("missing value")
+	case strings.HasPrefix(s, `"""`), strings.HasPrefix(s, "'''"), s[0] == '{':
+		return nil, fmt.Errorf("unsupported value %s", s)
+	case s[0] == '"':
+		return unquote(s)
+	case s[0] == '\'':
+		if len(s) < 2 || s[len(s)-1] != '\'' {
+			return nil, fmt.Errorf("unterminated string %s", s)
+		}
+		return s[1 : len(s)-1], nil
+	case s[0] == '[':
+		if s[len(s)-1] != ']' {
+			return nil, fmt.Errorf("unterminated array %s", s)
+		}
+		list := []any{}
+		for _, item := range splitlist(s[1 : len(s)-1]) {
+			v, err := tomlvalue(strings.TrimSpace(item))
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case s == "true":
+		return true, nil
+	case s == "false":
+		return false, nil
+	}
+	/* Dates and times are kept as strings */
+	return number(strings.ReplaceAll(s, "_", "")), nil
+}
@@ -0,0 +1,66 @@
+package smu
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFrontMatter(t *testing.T) {
+	tests := []struct {
+		text, body string
+		want       map[string]any
+	}{
+		{"---\ntitle: Hello # c\nn: 3\nf: 1.5\nok: true\nnone: ~\nq: 'it''s'\ntags: [a, \"b, c\"]\nlist:\n- x\n- y\nmap:\n  k: v\n  items:\n    - name: a\n      n: 1\n    - b\ntext: |\n  l1\n   l2\nfold: >\n  a\n  b\n---\n# body\n",
+			"# body\n", map[string]any{
+				"title": "Hello", "n": int64(3), "f": 1.5, "ok": true, "none": nil, "q": "it's",
+				"tags": []any{"a", "b, c"}, "list": []any{"x", "y"},
+				"map":  map[string]any{"k": "v", "items": []any{map[string]any{"name": "a", "n": int64(1)}, "b"}},
+				"text": "l1\n l2", "fold": "a b",
+			}},
+		{"+++\ntitle = \"Hi\" # c\nn = 1_000\ndate = 2024-01-02\narr = [\n  1,\n  2,\n]\na.b = 'x'\n[t]\nk = true\n[t.u]\nz = 1.5\n+++\nbody\n",
+			"body\n", map[string]any{
+				"title": "Hi", "n": int64(1000), "date": "2024-01-02", "arr": []any{int64(1), int64(2)},
+				"a": map[string]any{"b": "x"},
+				"t": map[string]any{"k": true, "u": map[string]any{"z": 1.5}},
+			}},
+		{"---\na: 1\n...\nbody\n", "body\n", map[string]any{"a": int64(1)}},
+		{"---\r\na: 1\r\n---\r\nbody\r\n", "body\r\n", map[string]any{"a": int64(1)}},
+		{"---\n---\nbody\n", "body\n", map[string]any{}},
+
+		/* Without front matter, or with an unterminated one, the text is
+		 * left as it is */
+		{"no front matter\n---\n", "no front matter\n---\n", nil},
+		{" ---\na: 1\n---\n", " ---\na: 1\n---\n", nil},
+		{"---\na: 1\n", "---\na: 1\n", nil},
+	}
+	for _, tt := range tests {
+		meta, body, err := FrontMatter([]byte(tt.text))
+		if err != nil || string(body) != tt.body || !reflect.DeepEqual(meta, tt.want) {
+			t.Errorf("%q:\ngot  %#v, %q, %v\nwant %#v, %q", tt.text, meta, body, err, tt.want, tt.body)
+		}
+	}
+}
+
+// TestFrontMatterErrors checks that errors give the line in the document.
+func TestFrontMatterErrors(t *testing.T) {
+	tests := []struct{ text, want string }{
+		{"---\na: 1\nb\n---\n", "front matter line 3: expected key: value"},
+		{"---\na: 1\n  b: 2\n---\n", "front matter line 3: unexpected indentation"},
+		{"---\n\n# c\nq: \"bad\n---\n", "front matter line 4: invalid string \"bad"},
+		{"---\nq: 'bad\n---\n", "front matter line 2: unterminated string 'bad"},
+		{"---\nl: [a, b\n---\n", "front matter line 2: unterminated list [a, b"},
+		{"---\n- a\n---\n", "front matter line 2: expected key: value"},
+		{"+++\na = 1\nb\n+++\n", "front matter line 3: expected key = value"},
+		{"+++\n\na =\n+++\n", "front matter line 3: missing value"},
+		{"+++\ns = \"x\n+++\n", "front matter line 2: invalid string \"x"},
+		{"+++\n[[t]]\n+++\n", "front matter line 2: unsupported table [[t]]"},
+		{"+++\na = 1\n[a]\n+++\n", "front matter line 3: a is not a table"},
+		{"+++\ns = '''x'''\n+++\n", "front matter line 2: unsupported value '''x'''"},
+	}
+	for _, tt := range tests {
+		meta, body, err := FrontMatter([]byte(tt.text))
+		if err == nil || err.Error() != tt.want || meta != nil || string(body) != tt.text {
+			t.Errorf("%q:\ngot  %v, %v, %q\nwant %s", tt.text, err, meta, body, tt.want)
+		}
+	}
+}
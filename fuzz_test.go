@@ -0,0 +1,145 @@
+package smu
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/* fuzzSeeds are inputs that once crashed a parser */
+var fuzzSeeds = []string{
+	"[",
+	"[a](",
+	"[a](b",
+	"![a](b \"",
+	"[a]",
+	"#",
+	"# ",
+	">",
+	"- - -",
+	"|",
+	"| a |\n|",
+	"| a |\n|-",
+	"| a |\n|:",
+	"*",
+	"**a",
+	"`",
+	"<",
+	"<a",
+	"<!--",
+	"```",
+	"~~~\n",
+	"a\n=",
+	"a\n-",
+	"* ",
+	"1.",
+	"1. a\n\n",
+	"a\n: ",
+	"[^",
+	"[^a]:",
+	"$",
+	"$$",
+	":::",
+	"> [!",
+	"\\",
+	"&",
+	"\xff",
+	"\xe4\xbd",
+}
+
+/* fuzzOptions are the renderer options every input is processed with */
+var fuzzOptions = []Options{
+	{},
+	{Safe: true, NoHTML: true},
+	{HeadingAnchors: true, Highlight: true, Math: true, Autolink: true, SourcePos: true,
+		Strikethrough: true, Mark: true, Superscript: true, Subscript: true},
+	{CommonMark: true, SourcePos: true},
+}
+
+func addSeeds(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add([]byte(s))
+	}
+	files, _ := filepath.Glob(filepath.Join("testdata", "golden", "*.smu"))
+	for _, name := range append(files, "testdoc") {
+		if text, err := os.ReadFile(name); err == nil {
+			f.Add(text)
+		}
+	}
+}
+
+func FuzzProcess(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, text []byte) {
+		for _, opts := range fuzzOptions {
+			New(opts).Process(text)
+		}
+	})
+}
+
+/* fuzzParser runs parse on its own at the start of a document */
+func fuzzParser(f *testing.F, parse Parser) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, text []byte) {
+		/* Parsers are never run at the end of the text */
+		if len(text) == 0 {
+			return
+		}
+		for _, opts := range fuzzOptions[:3] {
+			for _, newblock := range []bool{true, false} {
+				r := New(opts)
+				r.reset()
+				r.cur = NewNode(Document)
+				if n := abs(parse(r, text, newblock)); n > len(text) {
+					t.Fatalf("consumed %d of %d bytes", n, len(text))
+				}
+			}
+		}
+	})
+}
+
+func FuzzDoadmonition(f *testing.F) { fuzzParser(f, (*Renderer).doadmonition) }
+func FuzzDoautolink(f *testing.F)   { fuzzParser(f, (*Renderer).doautolink) }
+func FuzzDocodefence(f *testing.F)  { fuzzParser(f, (*Renderer).docodefence) }
+func FuzzDocomment(f *testing.F)    { fuzzParser(f, (*Renderer).docomment) }
+func FuzzDodeflist(f *testing.F)    { fuzzParser(f, (*Renderer).dodeflist) }
+func FuzzDofootnote(f *testing.F)   { fuzzParser(f, (*Renderer).dofootnote) }
+func FuzzDohtml(f *testing.F)       { fuzzParser(f, (*Renderer).dohtml) }
+func FuzzDolineprefix(f *testing.F) { fuzzParser(f, (*Renderer).dolineprefix) }
+func FuzzDolink(f *testing.F)       { fuzzParser(f, (*Renderer).dolink) }
+func FuzzDolist(f *testing.F)       { fuzzParser(f, (*Renderer).dolist) }
+func FuzzDomath(f *testing.F)       { fuzzParser(f, (*Renderer).domath) }
+func FuzzDomathblock(f *testing.F)  { fuzzParser(f, (*Renderer).domathblock) }
+func FuzzDoparagraph(f *testing.F)  { fuzzParser(f, (*Renderer).doparagraph) }
+func FuzzDoreplace(f *testing.F)    { fuzzParser(f, (*Renderer).doreplace) }
+func FuzzDoshortlink(f *testing.F)  { fuzzParser(f, (*Renderer).doshortlink) }
+func FuzzDosurround(f *testing.F)   { fuzzParser(f, (*Renderer).dosurround) }
+func FuzzDotable(f *testing.F)      { fuzzParser(f, (*Renderer).dotable) }
+func FuzzDounderline(f *testing.F)  { fuzzParser(f, (*Renderer).dounderline) }
+
+func TestTryProcess(t *testing.T) {
+	r := New(Options{})
+	r.AddParser(func(r *Renderer, text []byte, newblock bool) int {
+		if bytes.HasPrefix(text, []byte("boom")) {
+			panic("boom")
+		}
+		return 0
+	}, PriorityParagraph)
+
+	out, err := r.TryProcess([]byte("text\n\nboom"))
+	var perr *PanicError
+	if !errors.As(err, &perr) || perr.Value != "boom" || out != nil {
+		t.Fatalf("TryProcess = %q, %v, want a panic error", out, err)
+	}
+	if err := r.Render(&bytes.Buffer{}, bytes.NewReader([]byte("boom"))); !errors.As(err, &perr) {
+		t.Fatalf("Render = %v, want a panic error", err)
+	}
+
+	/* The renderer is usable after a panic */
+	out, err = r.TryProcess([]byte("fine"))
+	if want := "<p>fine</p>\n"; err != nil || string(out) != want {
+		t.Fatalf("TryProcess = %q, %v, want %q", out, err, want)
+	}
+}
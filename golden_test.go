@@ -0,0 +1,104 @@
+package smu
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+/* options of golden files that need more than the defaults */
+var goldenOptions = map[string]Options{
+	"extensions": {Strikethrough: true, Mark: true, Superscript: true, Subscript: true},
+}
+
+/* goldenInputs returns the inputs in testdata/golden by name */
+func goldenInputs(t *testing.T) map[string][]byte {
+	files, err := filepath.Glob(filepath.Join("testdata", "golden", "*.smu"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs := map[string][]byte{}
+	for _, f := range files {
+		text, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inputs[strings.TrimSuffix(filepath.Base(f), ".smu")] = text
+	}
+	return inputs
+}
+
+// TestGolden renders every testdata/golden/*.smu and compares it to the
+// .html next to it. Run "go test -run Golden -update" to rewrite them.
+func TestGolden(t *testing.T) {
+	for name, text := range goldenInputs(t) {
+		t.Run(name, func(t *testing.T) {
+			got := New(goldenOptions[name]).Process(text)
+			golden := filepath.Join("testdata", "golden", name+".html")
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s differs from %s:\ngot:\n%s\nwant:\n%s", name, golden, got, want)
+			}
+		})
+	}
+}
+
+// TestGoldenCoverage checks that the golden files use every built-in line
+// prefix, underline, surround and replacement.
+func TestGoldenCoverage(t *testing.T) {
+	var lines []string
+	var all strings.Builder
+	for _, text := range goldenInputs(t) {
+		lines = append(lines, strings.Split(string(text), "\n")...)
+		all.Write(text)
+	}
+	linestart := func(prefix string) bool {
+		for _, l := range lines {
+			if strings.HasPrefix(l+"\n", prefix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, tag := range lineprefixs {
+		if !linestart(tag.search) {
+			t.Errorf("no line starts with line prefix %q", tag.search)
+		}
+	}
+	for _, tag := range underlines {
+		found := false
+		for i, l := range lines {
+			if i > 0 && lines[i-1] != "" && l != "" && strings.Trim(l, tag.search) == "" {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("no line is underlined with %q", tag.search)
+		}
+	}
+	for _, tag := range surrounds {
+		if !strings.Contains(all.String(), tag.search) {
+			t.Errorf("surround %q is not used", tag.search)
+		}
+	}
+	for _, replace := range replaces {
+		if !strings.Contains(all.String(), replace[0]) {
+			t.Errorf("replacement of %q is not used", replace[0])
+		}
+	}
+}
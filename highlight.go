@@ -0,0 +1,362 @@
+package smu
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"strings"
+)
+
+// Highlighter writes source code in the given language as HTML. It is
+// called for fenced code blocks with the first word of the info string as
+// lang.
+type Highlighter interface {
+	Highlight(w io.Writer, lang string, code []byte) error
+}
+
+// HighlighterFunc adapts a function to the Highlighter interface.
+type HighlighterFunc func(w io.Writer, lang string, code []byte) error
+
+func (f HighlighterFunc) Highlight(w io.Writer, lang string, code []byte) error {
+	return f(w, lang, code)
+}
+
+// AddHighlighter registers h for fenced code blocks in lang. It replaces
+// the built-in highlighter for lang, if any.
+func (r *Renderer) AddHighlighter(lang string, h Highlighter) {
+	if r.highlighters == nil {
+		r.highlighters = map[string]Highlighter{}
+	}
+	r.highlighters[strings.ToLower(lang)] = h
+}
+
+// HighlightCSS is a stylesheet for the classes of the built-in
+// highlighter, which are the short token classes used by Pygments.
+const HighlightCSS = `
+pre code .k, pre code .kt { color: #a626a4; }
+pre code .nb { color: #0184bc; }
+pre code .s { color: #50a14f; }
+pre code .m { color: #986801; }
+pre code .c { color: #a0a1a7; font-style: italic; }
+pre code .cp { color: #c18401; }
+pre code .nt { color: #e45649; }
+pre code .nv { color: #e45649; }
+pre code .gi { color: #22863a; background: #f0fff4; }
+pre code .gd { color: #b31d28; background: #ffeef0; }
+pre code .gu { color: #6f42c1; font-weight: bold; }
+pre code .gh { font-weight: bold; }
+`
+
+/* lexer describes the tokens of a language for the built-in highlighter */
+type lexer struct {
+	keywords     []string
+	types        []string
+	builtins     []string
+	lineComments []string
+	blockComment [2]string
+	quotes       string
+	triple       bool   /* python style triple quoted strings */
+	spaceComment bool   /* line comments must follow white space */
+	preproc      bool   /* lines starting with # are preprocessor lines */
+	variables    bool   /* shell style $var */
+	keys         bool   /* names and strings followed by : are keys */
+	identChars   string /* further characters allowed inside names */
+}
+
+var lexers map[string]*lexer
+
+func init() {
+	golang := &lexer{
+		keywords: []string{"break", "case", "chan", "const", "continue", "default",
+			"defer", "else", "fallthrough", "for", "func", "go", "goto", "if",
+			"import", "interface", "map", "package", "range", "return", "select",
+			"struct", "switch", "type", "var"},
+		types: []string{"any", "bool", "byte", "comparable", "complex64",
+			"complex128", "error", "float32", "float64", "int", "int8", "int16",
+			"int32", "int64", "rune", "string", "uint", "uint8", "uint16",
+			"uint32", "uint64", "uintptr"},
+		builtins: []string{"append", "cap", "clear", "close", "complex", "copy",
+			"delete", "false", "imag", "iota", "len", "make", "max", "min",
+			"new", "nil", "panic", "print", "println", "real", "recover", "true"},
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"'`",
+	}
+	c := &lexer{
+		keywords: []string{"auto", "break", "case", "const", "continue", "default",
+			"do", "else", "enum", "extern", "for", "goto", "if", "inline",
+			"register", "restrict", "return", "sizeof", "static", "struct",
+			"switch", "typedef", "union", "volatile", "while"},
+		types: []string{"_Bool", "bool", "char", "double", "float", "int", "long",
+			"short", "signed", "size_t", "ssize_t", "unsigned", "void"},
+		builtins:     []string{"NULL", "false", "true"},
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"'",
+		preproc:      true,
+	}
+	python := &lexer{
+		keywords: []string{"False", "None", "True", "and", "as", "assert", "async",
+			"await", "break", "class", "continue", "def", "del", "elif", "else",
+			"except", "finally", "for", "from", "global", "if", "import", "in",
+			"is", "lambda", "nonlocal", "not", "or", "pass", "raise", "return",
+			"try", "while", "with", "yield"},
+		builtins: []string{"bool", "dict", "float", "int", "isinstance", "len",
+			"list", "open", "print", "range", "self", "set", "str", "super",
+			"tuple", "type"},
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+		triple:       true,
+	}
+	shell := &lexer{
+		keywords: []string{"case", "do", "done", "elif", "else", "esac", "fi",
+			"for", "function", "if", "in", "return", "select", "then", "time",
+			"until", "while"},
+		builtins: []string{"alias", "cd", "echo", "eval", "exec", "exit",
+			"export", "local", "printf", "read", "set", "shift", "source", "test",
+			"trap", "unset"},
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+		spaceComment: true,
+		variables:    true,
+		identChars:   "-",
+	}
+	json := &lexer{
+		keywords: []string{"false", "null", "true"},
+		quotes:   "\"",
+		keys:     true,
+	}
+	yaml := &lexer{
+		keywords: []string{"False", "NO", "No", "Null", "TRUE", "True", "YES",
+			"Yes", "false", "no", "null", "true", "yes"},
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+		spaceComment: true,
+		keys:         true,
+		identChars:   "-.",
+	}
+
+	lexers = map[string]*lexer{
+		"go": golang, "golang": golang,
+		"c": c, "h": c,
+		"python": python, "py": python,
+		"sh": shell, "bash": shell, "shell": shell, "zsh": shell,
+		"json": json,
+		"yaml": yaml, "yml": yaml,
+	}
+}
+
+/* builtin is the highlighter for the languages in lexers and diffs */
+var builtin = HighlighterFunc(func(w io.Writer, lang string, code []byte) error {
+	var buf bytes.Buffer
+	switch lang {
+	case "diff", "patch":
+		highlightDiff(&buf, code)
+	default:
+		lexers[lang].highlight(&buf, code)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+})
+
+var htmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\"", "&quot;")
+
+func span(w *bytes.Buffer, class string, text []byte) {
+	w.WriteString("<span class=\"")
+	w.WriteString(class)
+	w.WriteString("\">")
+	htmlEscaper.WriteString(w, string(text))
+	w.WriteString("</span>")
+}
+
+func highlightDiff(w *bytes.Buffer, code []byte) {
+	for len(code) > 0 {
+		line := code
+		rest := []byte(nil)
+		if i := bytes.IndexByte(code, '\n'); i != -1 {
+			line, rest = code[:i], code[i:i+1]
+			code = code[i+1:]
+		} else {
+			code = nil
+		}
+		class := ""
+		switch {
+		case bytes.HasPrefix(line, []byte("diff ")), bytes.HasPrefix(line, []byte("index ")):
+			class = "gh"
+		case bytes.HasPrefix(line, []byte("+")):
+			class = "gi"
+		case bytes.HasPrefix(line, []byte("-")):
+			class = "gd"
+		case bytes.HasPrefix(line, []byte("@")):
+			class = "gu"
+		}
+		if class == "" {
+			htmlEscaper.WriteString(w, string(line))
+		} else {
+			span(w, class, line)
+		}
+		w.Write(rest)
+	}
+}
+
+func (l *lexer) ident(c byte) bool {
+	return isAlnum(c) || c == '_' || c >= 0x80 || strings.IndexByte(l.identChars, c) != -1
+}
+
+func (l *lexer) highlight(w *bytes.Buffer, code []byte) {
+	end := len(code)
+	linestart := true
+	for p := 0; p < end; {
+		c := code[p]
+		start := p
+
+		switch {
+		case l.preproc && linestart && c == '#':
+			for p < end && code[p] != '\n' {
+				p++
+			}
+			span(w, "cp", code[start:p])
+			continue
+		case l.comment(code, p):
+			for p < end && code[p] != '\n' {
+				p++
+			}
+			span(w, "c", code[start:p])
+			continue
+		case l.blockComment[0] != "" && bytes.HasPrefix(code[p:], []byte(l.blockComment[0])):
+			if i := bytes.Index(code[p+2:], []byte(l.blockComment[1])); i != -1 {
+				p += 2 + i + len(l.blockComment[1])
+			} else {
+				p = end
+			}
+			span(w, "c", code[start:p])
+			continue
+		case strings.IndexByte(l.quotes, c) != -1:
+			p = l.str(code, p)
+			class := "s"
+			if l.keys && l.iskey(code, p, false) {
+				class = "nt"
+			}
+			span(w, class, code[start:p])
+			linestart = false
+			continue
+		case l.variables && c == '$' && p+1 < end:
+			p++
+			if code[p] == '{' {
+				if i := bytes.IndexByte(code[p:], '}'); i != -1 {
+					p += i + 1
+				}
+			} else {
+				for p < end && (isAlnum(code[p]) || code[p] == '_') {
+					p++
+				}
+				if p == start+1 && strings.IndexByte("#?@*!$0123456789-", code[p]) != -1 {
+					p++
+				}
+			}
+			if p > start+1 {
+				span(w, "nv", code[start:p])
+				linestart = false
+				continue
+			}
+			p = start
+		case isDigit(c) && (p == 0 || !l.ident(code[p-1])):
+			for p < end && (isAlnum(code[p]) || code[p] == '.' || code[p] == '_') {
+				p++
+			}
+			span(w, "m", code[start:p])
+			linestart = false
+			continue
+		case l.ident(c):
+			for p < end && l.ident(code[p]) {
+				p++
+			}
+			word := string(code[start:p])
+			switch {
+			case l.keys && l.iskey(code, p, true):
+				span(w, "nt", code[start:p])
+			case slices.Contains(l.keywords, word):
+				span(w, "k", code[start:p])
+			case slices.Contains(l.types, word):
+				span(w, "kt", code[start:p])
+			case slices.Contains(l.builtins, word):
+				span(w, "nb", code[start:p])
+			default:
+				htmlEscaper.WriteString(w, word)
+			}
+			linestart = false
+			continue
+		}
+
+		htmlEscaper.WriteString(w, string(code[p:p+1]))
+		if c == '\n' {
+			linestart = true
+		} else if c != ' ' && c != '\t' {
+			linestart = false
+		}
+		p++
+	}
+}
+
+/* comment reports whether a line comment starts at p */
+func (l *lexer) comment(code []byte, p int) bool {
+	if l.spaceComment && p > 0 && !isSpace(code[p-1]) && code[p-1] != '\n' {
+		return false
+	}
+	for _, prefix := range l.lineComments {
+		if bytes.HasPrefix(code[p:], []byte(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+/* str returns the end of the string literal starting at p */
+func (l *lexer) str(code []byte, p int) int {
+	end := len(code)
+	q := code[p]
+	if l.triple && bytes.HasPrefix(code[p:], []byte{q, q, q}) {
+		if i := bytes.Index(code[p+3:], []byte{q, q, q}); i != -1 {
+			return p + 3 + i + 3
+		}
+		return end
+	}
+	for p++; p < end; p++ {
+		switch {
+		case code[p] == '\\' && q != '`':
+			p++
+		case code[p] == q:
+			return p + 1
+		case code[p] == '\n' && q != '`':
+			return p
+		}
+	}
+	return end
+}
+
+/* iskey reports whether a colon follows the name or string ending at p.
+ * After names the colon must be followed by white space. */
+func (l *lexer) iskey(code []byte, p int, name bool) bool {
+	for p < len(code) && isSpace(code[p]) {
+		p++
+	}
+	if p == len(code) || code[p] != ':' {
+		return false
+	}
+	p++
+	if name {
+		return p == len(code) || isHTMLSpace(code[p])
+	}
+	return p == len(code) || code[p] != ':'
+}
+
+/* highlighter returns the highlighter for lang or nil */
+func (r *Renderer) highlighter(lang string) Highlighter {
+	if h, ok := r.highlighters[lang]; ok {
+		return h
+	}
+	if r.opts.Highlight && (lexers[lang] != nil || lang == "diff" || lang == "patch") {
+		return builtin
+	}
+	return nil
+}
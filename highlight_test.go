@@ -0,0 +1,98 @@
+package smu
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+/* code returns the contents of the first code element of out */
+func code(out string) string {
+	if i := strings.Index(out, "<code"); i >= 0 {
+		out = out[i:]
+		out = out[strings.IndexByte(out, '>')+1:]
+	}
+	out = strings.TrimPrefix(out, "\n")
+	if i := strings.Index(out, "</code>"); i >= 0 {
+		out = out[:i]
+	}
+	return out
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct{ text, want string }{
+		{"```go\nfunc f() int { return len(\"a<b\") } // c\n/* open\n```",
+			"<span class=\"k\">func</span> f() <span class=\"kt\">int</span> { <span class=\"k\">return</span> <span class=\"nb\">len</span>(<span class=\"s\">&quot;a&lt;b&quot;</span>) } <span class=\"c\">// c</span>\n<span class=\"c\">/* open\n</span>"},
+		{"```sh\necho $HOME ${x} $? a#b # c\n```",
+			"<span class=\"nb\">echo</span> <span class=\"nv\">$HOME</span> <span class=\"nv\">${x}</span> <span class=\"nv\">$?</span> a#b <span class=\"c\"># c</span>\n"},
+		{"```json\n{\"k\": \"v\", \"n\": 1.5e3, \"t\": true}\n```",
+			"{<span class=\"nt\">&quot;k&quot;</span>: <span class=\"s\">&quot;v&quot;</span>, <span class=\"nt\">&quot;n&quot;</span>: <span class=\"m\">1.5e3</span>, <span class=\"nt\">&quot;t&quot;</span>: <span class=\"k\">true</span>}\n"},
+		{"```diff\ndiff --git a b\n--- a\n+++ b\n@@ -1 +1 @@\n ctx\n```",
+			"<span class=\"gh\">diff --git a b</span>\n<span class=\"gd\">--- a</span>\n<span class=\"gi\">+++ b</span>\n<span class=\"gu\">@@ -1 +1 @@</span>\n ctx\n"},
+		{"```ruby\nputs 1\n```", "puts 1\n"},
+		{"    func f()", "func f()\n"},
+	}
+	for _, tt := range tests {
+		for _, cm := range []bool{false, true} {
+			out := string(New(Options{Highlight: true, CommonMark: cm}).Process([]byte(tt.text)))
+			if got := code(out); got != tt.want {
+				t.Errorf("%q CommonMark %v:\ngot  %q\nwant %q", tt.text, cm, got, tt.want)
+			}
+		}
+	}
+
+	/* Other languages by their lexers */
+	fragments := []struct{ text, want string }{
+		{"```yaml\nkey: v # c\n```", "<span class=\"c\"># c</span>"},
+		{"```python\ns = \"\"\"a\nb\"\"\"\n```", "<span class=\"s\">&quot;&quot;&quot;a\nb&quot;&quot;&quot;</span>"},
+		{"```c\n#include <x.h>\n```", "<span class=\"cp\">#include &lt;x.h&gt;</span>"},
+		{"```go {.x}\nvar\n```", "<pre class=\"x\"><code class=\"language-go\">\n<span class=\"k\">var</span>"},
+	}
+	for _, tt := range fragments {
+		if got := string(New(Options{Highlight: true}).Process([]byte(tt.text))); !strings.Contains(got, tt.want) {
+			t.Errorf("%q:\ngot  %q\nwant it to contain %q", tt.text, got, tt.want)
+		}
+	}
+
+	/* Without Highlight the code is only escaped */
+	out := New(Options{}).Process([]byte("```go\nvar a<b\n```"))
+	if got, want := code(string(out)), "var a&lt;b\n"; got != want {
+		t.Errorf("without Highlight:\ngot  %q\nwant %q", got, want)
+	}
+}
+
+func TestAddHighlighter(t *testing.T) {
+	r := New(Options{Highlight: true})
+	var langs []string
+	r.AddHighlighter("Go", HighlighterFunc(func(w io.Writer, lang string, code []byte) error {
+		langs = append(langs, lang)
+		_, err := fmt.Fprintf(w, "[%s]", code)
+		return err
+	}))
+	r.AddHighlighter("fail", HighlighterFunc(func(w io.Writer, lang string, code []byte) error {
+		return errors.New("fail")
+	}))
+
+	text := []byte("```go\nvar\n```\n\n    indented\n")
+	want := "<pre><code class=\"language-go\">\n[var\n]</code></pre>\n<pre><code>indented\n\n</code></pre>\n"
+	if got := string(r.Process(text)); got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+	if fmt.Sprint(langs) != "[go]" {
+		t.Errorf("highlighter called with %v, want [go]", langs)
+	}
+
+	/* The errors of a highlighter are returned */
+	var b strings.Builder
+	if err := r.Render(&b, strings.NewReader("```fail\nx\n```\n")); err == nil || err.Error() != "fail" {
+		t.Errorf("Render = %v, want fail", err)
+	}
+	if err := r.RenderNode(&b, r.Parse([]byte("```fail\nx\n```\n"))); err == nil || err.Error() != "fail" {
+		t.Errorf("RenderNode = %v, want fail", err)
+	}
+	if err := r.RenderNode(&b, r.Parse([]byte("```go\nx\n```\n"))); err != nil {
+		t.Errorf("RenderNode = %v after an error", err)
+	}
+}
@@ -0,0 +1,525 @@
+package smu
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// RenderNode writes the HTML for n and its descendants to w. A Document
+// is written block by block. RenderNode returns the first write error.
+func (r *Renderer) RenderNode(w io.Writer, n *Node) error {
+	r.w = w
+	r.err = nil
+	if r.opts.CommonMark {
+		r.cmhtml(n)
+		r.flush()
+	} else if n.Type == Document {
+		for _, c := range n.Children {
+			r.html(c)
+			r.flush()
+		}
+	} else {
+		r.html(n)
+		r.flush()
+	}
+	r.w = nil
+	return r.err
+}
+
+/* flush hands everything rendered so far to the writer */
+func (r *Renderer) flush() {
+	if r.err == nil && r.out.Len() > 0 {
+		_, r.err = r.w.Write(r.out.Bytes())
+	}
+	r.out.Reset()
+}
+
+func (r *Renderer) children(n *Node) {
+	for _, c := range n.Children {
+		r.html(c)
+	}
+}
+
+func (r *Renderer) html(n *Node) {
+	switch n.Type {
+	case Document:
+		r.children(n)
+	case Paragraph:
+		r.out.WriteString("<p")
+		r.sourcepos(n)
+		r.out.WriteString(">")
+		r.children(n)
+		r.out.WriteString("</p>\n")
+	case Heading:
+		id := ""
+		if r.opts.HeadingIDs || r.opts.HeadingAnchors {
+			id = n.ID
+		}
+		fmt.Fprintf(&r.out, "<h%d", n.Level)
+		r.sourcepos(n)
+		if id != "" {
+			r.out.WriteString(" id=\"")
+			r.hprint([]byte(id))
+			r.out.WriteString("\"")
+		}
+		r.out.WriteString(">")
+		r.children(n)
+		if id != "" && r.opts.HeadingAnchors {
+			r.out.WriteString("<a class=\"anchor\" href=\"#")
+			r.hprint([]byte(id))
+			r.out.WriteString("\">#</a>")
+		}
+		fmt.Fprintf(&r.out, "</h%d>\n", n.Level)
+	case List:
+		if !n.Ordered {
+			r.out.WriteString("<ul")
+		} else if n.Start == 1 {
+			r.out.WriteString("<ol")
+		} else {
+			fmt.Fprintf(&r.out, "<ol start=\"%d\"", n.Start)
+		}
+		r.sourcepos(n)
+		r.out.WriteString(">\n")
+		r.children(n)
+		if !n.Ordered {
+			r.out.WriteString("</ul>\n")
+		} else {
+			r.out.WriteString("</ol>\n")
+		}
+	case ListItem:
+		r.out.WriteString("<li")
+		r.sourcepos(n)
+		if !n.Task {
+			r.out.WriteString(">")
+		} else if n.Checked {
+			r.out.WriteString(" class=\"task-list-item\"><input type=\"checkbox\" checked disabled />")
+		} else {
+			r.out.WriteString(" class=\"task-list-item\"><input type=\"checkbox\" disabled />")
+		}
+		r.children(n)
+		r.out.WriteString("</li>\n")
+	case DefinitionList:
+		r.out.WriteString("<dl")
+		r.sourcepos(n)
+		r.out.WriteString(">\n")
+		r.children(n)
+		r.out.WriteString("</dl>\n")
+	case DefinitionTerm:
+		r.out.WriteString("<dt")
+		r.sourcepos(n)
+		r.out.WriteString(">")
+		r.children(n)
+		r.out.WriteString("</dt>\n")
+	case Definition:
+		r.out.WriteString("<dd")
+		r.sourcepos(n)
+		r.out.WriteString(">")
+		r.children(n)
+		r.out.WriteString("</dd>\n")
+	case CodeBlock:
+		r.out.WriteString("<pre")
+		r.sourcepos(n)
+		for _, a := range n.Attrs {
+			if a.Key != "id" && a.Key != "class" {
+				a.Key = "data-" + a.Key
+			}
+			r.out.WriteString(" ")
+			r.attr(a)
+		}
+		r.out.WriteString(">")
+		if n.Info == "" {
+			r.out.WriteString("<code>")
+		} else {
+			r.out.WriteString("<code class=\"language-")
+			r.hprint([]byte(n.Info))
+			r.out.WriteString("\">\n")
+		}
+		if h := r.highlighter(codelang(n.Info)); h != nil && n.Fenced {
+			if err := h.Highlight(&r.out, codelang(n.Info), n.Literal); err != nil && r.err == nil {
+				r.err = err
+			}
+		} else {
+			r.hprint(n.Literal)
+		}
+		if !n.Fenced {
+			r.out.WriteString("\n")
+		}
+		r.out.WriteString("</code></pre>\n")
+	case MathBlock:
+		r.mathhtml(n, true)
+		r.out.WriteString("\n")
+	case Blockquote:
+		r.out.WriteString("<blockquote")
+		r.sourcepos(n)
+		r.out.WriteString(">")
+		r.children(n)
+		r.out.WriteString("</blockquote>\n")
+	case Admonition:
+		r.out.WriteString("<div class=\"admonition ")
+		r.hprint([]byte(n.Class))
+		r.out.WriteString("\"")
+		r.sourcepos(n)
+		r.out.WriteString(">\n")
+		if n.Title != "" {
+			r.out.WriteString("<p class=\"admonition-title\">")
+			r.tprint([]byte(n.Title))
+			r.out.WriteString("</p>\n")
+		}
+		r.children(n)
+		r.out.WriteString("</div>\n")
+	case Table:
+		r.out.WriteString("<table")
+		r.sourcepos(n)
+		r.out.WriteString(">\n")
+		body := false
+		for i, c := range n.Children {
+			switch {
+			case i == 0 && c.Header:
+				r.out.WriteString("<thead>\n")
+			case !c.Header && !body:
+				r.out.WriteString("<tbody>\n")
+				body = true
+			}
+			r.html(c)
+			if c.Header && (i+1 == len(n.Children) || !n.Children[i+1].Header) {
+				r.out.WriteString("</thead>\n")
+			}
+		}
+		if body {
+			r.out.WriteString("</tbody>\n")
+		}
+		r.out.WriteString("</table>\n")
+	case TableRow:
+		r.out.WriteString("<tr")
+		r.sourcepos(n)
+		r.out.WriteString(">")
+		r.children(n)
+		r.out.WriteString("</tr>\n")
+	case TableCell:
+		typ := 'd'
+		if n.Header {
+			typ = 'h'
+		}
+		fmt.Fprintf(&r.out, "<t%c%s", typ, alignTable[n.Align])
+		r.sourcepos(n)
+		r.out.WriteString(">")
+		r.children(n)
+		fmt.Fprintf(&r.out, "</t%c>", typ)
+	case Footnotes:
+		r.out.WriteString("<section class=\"footnotes\">\n<ol>\n")
+		r.children(n)
+		r.out.WriteString("</ol>\n</section>\n")
+	case Footnote:
+		fmt.Fprintf(&r.out, "<li id=\"fn-%d\"", n.Index)
+		r.sourcepos(n)
+		r.out.WriteString(">\n")
+		/* The back-links go at the end of the last paragraph */
+		last := n.LastChild()
+		for _, c := range n.Children {
+			if c != last || c.Type != Paragraph {
+				r.html(c)
+				continue
+			}
+			r.out.WriteString("<p")
+			r.sourcepos(c)
+			r.out.WriteString(">")
+			r.children(c)
+			r.out.WriteString(" ")
+			r.backrefs(n)
+			r.out.WriteString("</p>\n")
+		}
+		if last == nil || last.Type != Paragraph {
+			r.out.WriteString("<p>")
+			r.backrefs(n)
+			r.out.WriteString("</p>\n")
+		}
+		r.out.WriteString("</li>\n")
+	case HorizontalRule:
+		r.out.WriteString("<hr")
+		r.sourcepos(n)
+		r.out.WriteString(" />\n")
+	case Text:
+		r.tprint(n.Literal)
+	case Link:
+		l := r.runhooks(r.linkHooks, n)
+		if l.HTML != nil {
+			r.out.Write(l.HTML)
+			return
+		}
+		/* Mail addresses in angular brackets are hidden from harvesters */
+		dest := r.linkdest(l.Dest)
+		email := n.Autolink && strings.HasPrefix(dest, mailto) &&
+			(!n.Bare || r.opts.ObfuscateEmail)
+		r.out.WriteString("<a href=\"")
+		if email {
+			r.out.WriteString("&#x6D;&#x61;i&#x6C;&#x74;&#x6F;:")
+			r.obfuscate([]byte(dest[len(mailto):]))
+		} else {
+			r.hprint([]byte(dest))
+		}
+		r.out.WriteString("\"")
+		if l.Title != "" {
+			r.out.WriteString(" title=\"")
+			r.hprint([]byte(l.Title))
+			r.out.WriteString("\"")
+		}
+		for _, a := range l.Attrs {
+			r.out.WriteString(" ")
+			r.attr(a)
+		}
+		r.out.WriteString(">")
+		if email {
+			r.obfuscate(plaintext(n))
+		} else {
+			r.children(n)
+		}
+		r.out.WriteString("</a>")
+	case Image:
+		l := r.runhooks(r.imageHooks, n)
+		if l.HTML != nil {
+			r.out.Write(l.HTML)
+			return
+		}
+		r.out.WriteString("<img src=\"")
+		r.hprint([]byte(r.linkdest(l.Dest)))
+		r.out.WriteString("\" alt=\"")
+		r.hprint(plaintext(n))
+		r.out.WriteString("\" ")
+		if l.Title != "" {
+			r.out.WriteString("title=\"")
+			r.hprint([]byte(l.Title))
+			r.out.WriteString("\" ")
+		}
+		for _, a := range l.Attrs {
+			r.attr(a)
+			r.out.WriteString(" ")
+		}
+		r.out.WriteString("/>")
+	case Emphasis:
+		r.out.WriteString("<em>")
+		r.children(n)
+		r.out.WriteString("</em>")
+	case Strong:
+		r.out.WriteString("<strong>")
+		r.children(n)
+		r.out.WriteString("</strong>")
+	case Strikethrough:
+		r.out.WriteString("<del>")
+		r.children(n)
+		r.out.WriteString("</del>")
+	case Mark:
+		r.out.WriteString("<mark>")
+		r.children(n)
+		r.out.WriteString("</mark>")
+	case Superscript:
+		r.out.WriteString("<sup>")
+		r.children(n)
+		r.out.WriteString("</sup>")
+	case Subscript:
+		r.out.WriteString("<sub>")
+		r.children(n)
+		r.out.WriteString("</sub>")
+	case FootnoteRef:
+		fmt.Fprintf(&r.out, "<sup class=\"footnote-ref\"><a href=\"#fn-%d\" id=\"%s\">%d</a></sup>",
+			n.Index, fnrefid(n.Index, n.Refs), n.Index)
+	case Code:
+		r.out.WriteString("<code>")
+		r.hprint(n.Literal)
+		r.out.WriteString("</code>")
+	case Math:
+		r.mathhtml(n, n.Display)
+	case RawHTML:
+		if r.opts.Safe {
+			r.sanitize(n.Literal)
+		} else {
+			r.out.Write(n.Literal)
+		}
+	case Comment:
+		if !r.opts.Safe {
+			r.out.Write(n.Literal)
+			r.out.WriteString("\n")
+		}
+	case LineBreak:
+		r.out.WriteString("<br />\n")
+	case CustomBlock, CustomInline:
+		r.out.WriteString("<" + n.Element)
+		if n.Class != "" {
+			r.out.WriteString(" class=\"")
+			r.hprint([]byte(n.Class))
+			r.out.WriteString("\"")
+		}
+		r.sourcepos(n)
+		r.out.WriteString(">")
+		r.hprint(n.Literal)
+		r.children(n)
+		r.out.WriteString("</" + n.Element + ">")
+		if n.Type == CustomBlock {
+			r.out.WriteString("\n")
+		}
+	}
+}
+
+const mailto = "mailto:"
+
+/* codelang returns the language of a code block, the first word of its
+ * info string */
+func codelang(info string) string {
+	if f := strings.Fields(info); len(f) > 0 {
+		return strings.ToLower(f[0])
+	}
+	return ""
+}
+
+// LinkInfo describes a link or image that is about to be rendered.
+type LinkInfo struct {
+	Node  *Node  // Link or Image node, Node.Autolink marks <url> and bare links
+	Dest  string // destination, may be rewritten
+	Title string // title, may be rewritten
+	Text  string // plain text of a link or alt text of an image
+	Attrs []Attr // further attributes of the element
+
+	// HTML, if set, is written instead of the element.
+	HTML []byte
+}
+
+// LinkHook inspects and modifies a link or image before it is rendered.
+type LinkHook func(l *LinkInfo)
+
+// AddLinkHook registers h to be called for every link, including links
+// written as <url> or <address>. Hooks run in the order they were added.
+func (r *Renderer) AddLinkHook(h LinkHook) {
+	r.linkHooks = append(r.linkHooks, h)
+}
+
+// AddImageHook registers h to be called for every image.
+func (r *Renderer) AddImageHook(h LinkHook) {
+	r.imageHooks = append(r.imageHooks, h)
+}
+
+func (r *Renderer) runhooks(hooks []LinkHook, n *Node) *LinkInfo {
+	l := &LinkInfo{Node: n, Dest: n.Dest, Title: n.Title}
+	if len(hooks) == 0 {
+		return l
+	}
+	l.Text = string(plaintext(n))
+	for _, h := range hooks {
+		h(l)
+	}
+	return l
+}
+
+/* fnrefid returns the id of the ref-th reference to footnote index */
+func fnrefid(index, ref int) string {
+	if ref <= 1 {
+		return "fnref-" + strconv.Itoa(index)
+	}
+	return "fnref-" + strconv.Itoa(index) + "-" + strconv.Itoa(ref)
+}
+
+/* backrefs writes the links from footnote n back to its references */
+func (r *Renderer) backrefs(n *Node) {
+	for i := 1; i <= n.Refs; i++ {
+		if i > 1 {
+			r.out.WriteString(" ")
+		}
+		fmt.Fprintf(&r.out, "<a href=\"#%s\" class=\"footnote-backref\">&#8617;</a>", fnrefid(n.Index, i))
+	}
+}
+
+/* attr writes a as key="value" */
+func (r *Renderer) attr(a Attr) {
+	r.out.WriteString(a.Key)
+	r.out.WriteString("=\"")
+	r.hprint([]byte(a.Value))
+	r.out.WriteString("\"")
+}
+
+/* plaintext returns the text content of n without any markup */
+func plaintext(n *Node) []byte {
+	var text []byte
+	Walk(n, func(c *Node, entering bool) WalkStatus {
+		if entering {
+			switch c.Type {
+			case Text, Code:
+				text = append(text, c.Literal...)
+			case RawHTML:
+				/* Escaped characters are character references, tags
+				 * are not text */
+				if len(c.Literal) > 0 && c.Literal[0] == '&' {
+					text = append(text, html.UnescapeString(string(c.Literal))...)
+				}
+			}
+		}
+		return GoToNext
+	})
+	return text
+}
+
+/* obfuscate writes text as numeric character references */
+func (r *Renderer) obfuscate(text []byte) {
+	for _, c := range text {
+		r.out.WriteString("&#")
+		r.out.WriteString(strconv.Itoa(int(c)))
+		r.out.WriteString(";")
+	}
+}
+
+/* hprint writes text escaped for use in an attribute value. It stops at
+ * the first invalid UTF-8. */
+func (r *Renderer) hprint(text []byte) {
+	for len(text) > 0 {
+		/* Runs of ASCII that needs no escaping are written at once */
+		i := 0
+		for i < len(text) && text[i] < utf8.RuneSelf && text[i] != '&' &&
+			text[i] != '"' && text[i] != '>' && text[i] != '<' {
+			i++
+		}
+		r.out.Write(text[:i])
+		if text = text[i:]; len(text) == 0 {
+			break
+		}
+
+		c, size := utf8.DecodeRune(text)
+		switch c {
+		case utf8.RuneError:
+			return
+		case '&':
+			r.out.WriteString("&amp;")
+		case '"':
+			r.out.WriteString("&quot;")
+		case '>':
+			r.out.WriteString("&gt;")
+		case '<':
+			r.out.WriteString("&lt;")
+		default:
+			r.out.Write(text[:size])
+		}
+		text = text[size:]
+	}
+}
+
+/* tprint writes text escaped for use as element content */
+func (r *Renderer) tprint(text []byte) {
+	for len(text) > 0 {
+		i := bytes.IndexAny(text, "&<>")
+		if i == -1 {
+			r.out.Write(text)
+			return
+		}
+		r.out.Write(text[:i])
+		switch text[i] {
+		case '&':
+			r.out.WriteString("&amp;")
+		case '>':
+			r.out.WriteString("&gt;")
+		case '<':
+			r.out.WriteString("&lt;")
+		}
+		text = text[i+1:]
+	}
+}
@@ -0,0 +1,57 @@
+package smu
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestLinkHooks checks that hooks see every link and image, including
+// autolinks and reference links, and that their changes are rendered.
+func TestLinkHooks(t *testing.T) {
+	r := New(Options{})
+	var seen []string
+	r.AddLinkHook(func(l *LinkInfo) {
+		seen = append(seen, fmt.Sprintf("%s %s %v", l.Text, l.Dest, l.Node.Autolink))
+		l.Dest = strings.Replace(l.Dest, "http:", "https:", 1)
+	})
+	r.AddLinkHook(func(l *LinkInfo) {
+		if strings.HasPrefix(l.Dest, "https:") {
+			l.Attrs = append(l.Attrs, Attr{"rel", "external"})
+		}
+	})
+	r.AddLinkHook(func(l *LinkInfo) {
+		if l.Dest == "raw" {
+			l.HTML = []byte("<b>" + l.Text + "</b>")
+		}
+	})
+	r.AddImageHook(func(l *LinkInfo) {
+		l.Dest = "/img/" + l.Dest
+		l.Title = strings.ToUpper(l.Title)
+		l.Attrs = append(l.Attrs, Attr{"loading", "lazy"})
+	})
+
+	tests := []struct{ text, want string }{
+		{"[a](http://x \"t\")", "<p><a href=\"https://x\" title=\"t\" rel=\"external\">a</a></p>\n"},
+		{"[*b*](raw)", "<p><b>b</b></p>\n"},
+		{"[c](/local)", "<p><a href=\"/local\">c</a></p>\n"},
+		{"<http://y>", "<p><a href=\"https://y\" rel=\"external\">http://y</a></p>\n"},
+		{"[d][ref]\n\n[ref]: http://z\n", "<p><a href=\"https://z\" rel=\"external\">d</a></p>\n"},
+		{"![e](p.png \"t\")", "<p><img src=\"/img/p.png\" alt=\"e\" title=\"T\" loading=\"lazy\" /></p>\n"},
+	}
+	for _, tt := range tests {
+		if got := string(r.Process([]byte(tt.text))); got != tt.want {
+			t.Errorf("%q:\ngot  %q\nwant %q", tt.text, got, tt.want)
+		}
+	}
+
+	/* Mail addresses keep being hidden after the hooks */
+	seen = nil
+	out := string(r.Process([]byte("<me@example.com>")))
+	if want := "[me@example.com mailto:me@example.com true]"; fmt.Sprint(seen) != want {
+		t.Errorf("hook saw %v, want %s", seen, want)
+	}
+	if strings.Contains(out, "mailto:") || !strings.Contains(out, "<a href=\"&#x6D;&#x61;i&#x6C;&#x74;&#x6F;:") {
+		t.Errorf("mail address not hidden: %q", out)
+	}
+}
@@ -0,0 +1,301 @@
+package smu
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultMaxDepth is the nesting depth of blocks and inline markup used if
+// Options.MaxDepth is 0.
+const DefaultMaxDepth = 100
+
+// ErrLimit is returned by TryProcess and Render if the input exceeded one
+// of the limits set in Options. The output is complete, but the part of
+// the input beyond the limit is plain text or missing.
+var ErrLimit = errors.New("smu: input exceeds the limits of the renderer")
+
+/* Parsers get the text from their position to the end of a buffer, and
+ * searching from every position to the end would take quadratic time on
+ * input like thousands of "[" without "](". Searches are therefore cached
+ * by the end of the buffer and the distance of the result from it, which
+ * is the same for every later position. Buffers must therefore not be
+ * reused for other text while they are parsed: the keys keep them from
+ * being freed, so their addresses are not reused either. */
+
+type scankey struct {
+	end  *byte
+	sep  string
+	kind scankind
+}
+
+type scankind int
+
+const (
+	scanIndex   scankind = iota /* the first sep */
+	scanAny                     /* the first byte of the set sep */
+	scanNotAny                  /* the first byte not in the set sep */
+	scanLast                    /* the last sep */
+	scanLastNot                 /* the last byte not in the set sep */
+)
+
+/* scanhit is the result of searching the last from bytes of a buffer: sep
+ * was found at the last at bytes, or nowhere if at is -1. As the results
+ * of searching backwards do not depend on the start of the text, they are
+ * -1 for texts that start after them. */
+type scanhit struct {
+	from, at int
+}
+
+type matchkey struct {
+	end     *byte
+	open    byte
+	escapes bool
+}
+
+/* tagindex has the positions of the closing tags "</name>" in the last
+ * from bytes of a buffer, as descending distances from its end */
+type tagindex struct {
+	from int
+	tags map[string][]int
+}
+
+/* limits is the state of the limits and caches of a single Parse */
+type limits struct {
+	depth     int
+	steps     int
+	deadline  time.Time
+	exceeded  bool /* a limit was reached, see ErrLimit */
+	exhausted bool /* MaxSteps or Timeout was reached */
+
+	scans   map[scankey]scanhit
+	matches map[matchkey]map[int]int
+	tags    map[*byte]*tagindex
+}
+
+/* startlimits prepares the limits for parsing text and returns the part of
+ * text within Options.MaxSize */
+func (r *Renderer) startlimits(text []byte) []byte {
+	r.lim = limits{}
+	if r.opts.Timeout > 0 {
+		r.lim.deadline = time.Now().Add(r.opts.Timeout)
+	}
+	if max := r.opts.MaxSize; max > 0 && len(text) > max {
+		for max > 0 && !utf8.RuneStart(text[max]) {
+			max--
+		}
+		text = text[:max]
+		r.lim.exceeded = true
+	}
+	return text
+}
+
+/* step counts a step of the parser and reports whether it may go on */
+func (r *Renderer) step() bool {
+	if r.lim.exhausted {
+		return false
+	}
+	r.lim.steps++
+	if r.opts.MaxSteps > 0 && r.lim.steps > r.opts.MaxSteps ||
+		r.opts.Timeout > 0 && r.lim.steps%256 == 0 && time.Now().After(r.lim.deadline) {
+		r.lim.exhausted, r.lim.exceeded = true, true
+		return false
+	}
+	return true
+}
+
+/* nest enters a level of nesting and reports whether it is within
+ * Options.MaxDepth */
+func (r *Renderer) nest() bool {
+	r.lim.depth++
+	return r.within(r.lim.depth)
+}
+
+/* within reports whether markup nested depth levels deep is within
+ * Options.MaxDepth */
+func (r *Renderer) within(depth int) bool {
+	if depth > r.maxdepth() {
+		r.lim.exceeded = true
+		return false
+	}
+	return true
+}
+
+func (r *Renderer) maxdepth() int {
+	if r.opts.MaxDepth > 0 {
+		return r.opts.MaxDepth
+	}
+	return DefaultMaxDepth
+}
+
+/* index returns the position of the first sep in text, or -1 */
+func (r *Renderer) index(text []byte, sep string) int {
+	return r.scan(text, scankey{sep: sep})
+}
+
+/* indexany returns the position of the first byte of chars in text, or -1 */
+func (r *Renderer) indexany(text []byte, chars string) int {
+	return r.scan(text, scankey{sep: chars, kind: scanAny})
+}
+
+/* span returns the length of the run of bytes of chars text starts with */
+func (r *Renderer) span(text []byte, chars string) int {
+	if i := r.scan(text, scankey{sep: chars, kind: scanNotAny}); i != -1 {
+		return i
+	}
+	return len(text)
+}
+
+/* lastindex returns the position of the last sep in text, or -1 */
+func (r *Renderer) lastindex(text []byte, sep string) int {
+	return r.scan(text, scankey{sep: sep, kind: scanLast})
+}
+
+/* trimright returns the length of text without the bytes of chars at its
+ * end */
+func (r *Renderer) trimright(text []byte, chars string) int {
+	return r.scan(text, scankey{sep: chars, kind: scanLastNot}) + 1
+}
+
+func (r *Renderer) scan(text []byte, key scankey) int {
+	l := len(text)
+	if l == 0 {
+		return -1
+	}
+	key.end = &text[l-1]
+	back := key.kind == scanLast || key.kind == scanLastNot
+	if h, ok := r.lim.scans[key]; ok && l <= h.from {
+		switch {
+		case h.at == -1 || back && l < h.at:
+			return -1
+		case l >= h.at:
+			return l - h.at
+		}
+	}
+	var i int
+	switch key.kind {
+	case scanIndex:
+		i = bytes.Index(text, []byte(key.sep))
+	case scanAny:
+		i = bytes.IndexAny(text, key.sep)
+	case scanNotAny:
+		i = 0
+		for i < l && strings.IndexByte(key.sep, text[i]) != -1 {
+			i++
+		}
+		if i == l {
+			i = -1
+		}
+	case scanLast:
+		i = bytes.LastIndex(text, []byte(key.sep))
+	case scanLastNot:
+		i = l - 1
+		for i >= 0 && strings.IndexByte(key.sep, text[i]) != -1 {
+			i--
+		}
+	}
+	h := scanhit{from: l, at: -1}
+	if i != -1 {
+		h.at = l - i
+	}
+	if r.lim.scans == nil {
+		r.lim.scans = map[scankey]scanhit{}
+	}
+	r.lim.scans[key] = h
+	return i
+}
+
+/* closing returns the position of the close delimiter that matches the
+ * open one at p, or -1. A backslash escapes the next byte if escapes is
+ * set. The matches of the delimiters passed on the way are remembered, so
+ * finding all of them takes linear time. */
+func (r *Renderer) closing(text []byte, p int, open, close byte, escapes bool) int {
+	l := len(text)
+	key := matchkey{&text[l-1], open, escapes}
+	memo := r.lim.matches[key]
+	if memo == nil {
+		memo = map[int]int{}
+		if r.lim.matches == nil {
+			r.lim.matches = map[matchkey]map[int]int{}
+		}
+		r.lim.matches[key] = memo
+	}
+	if m, ok := memo[l-p]; ok {
+		if m == -1 {
+			return -1
+		}
+		return l - m
+	}
+
+	/* memo maps distances from the end, as text may be a part of the
+	 * text that was scanned before */
+	stack := []int{p}
+	for q := p + 1; q < l; q++ {
+		switch c := text[q]; {
+		case c == '\\' && escapes:
+			q++
+		case c == open:
+			if m, ok := memo[l-q]; ok {
+				if m == -1 {
+					q = l
+					break
+				}
+				q = l - m
+				continue
+			}
+			stack = append(stack, q)
+		case c == close:
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			memo[l-top] = l - q
+			if len(stack) == 0 {
+				return q
+			}
+		}
+	}
+	for _, s := range stack {
+		memo[l-s] = -1
+	}
+	return -1
+}
+
+/* closetag returns the position of the first "</tag>" in text, or -1 */
+func (r *Renderer) closetag(text []byte, tag string) int {
+	l := len(text)
+	if l == 0 {
+		return -1
+	}
+	end := &text[l-1]
+	idx := r.lim.tags[end]
+	if idx == nil || l > idx.from {
+		idx = &tagindex{from: l, tags: map[string][]int{}}
+		for p := 0; p < l; p++ {
+			i := bytes.Index(text[p:], []byte("</"))
+			if i == -1 {
+				break
+			}
+			p += i
+			q := p + 2
+			for q < l && isAlnum(text[q]) {
+				q++
+			}
+			if q > p+2 && q < l && text[q] == '>' {
+				name := string(text[p+2 : q])
+				idx.tags[name] = append(idx.tags[name], l-p)
+			}
+		}
+		if r.lim.tags == nil {
+			r.lim.tags = map[*byte]*tagindex{}
+		}
+		r.lim.tags[end] = idx
+	}
+	at := idx.tags[tag]
+	i := sort.Search(len(at), func(i int) bool { return at[i] <= l })
+	if i == len(at) {
+		return -1
+	}
+	return l - at[i]
+}
@@ -0,0 +1,164 @@
+package smu
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaxDepth(t *testing.T) {
+	text := []byte(strings.Repeat("> ", 5) + "deep")
+	out, err := New(Options{MaxDepth: 3}).TryProcess(text)
+	if !errors.Is(err, ErrLimit) {
+		t.Errorf("err = %v, want ErrLimit", err)
+	}
+	if got := strings.Count(string(out), "<blockquote>"); got != 3 {
+		t.Errorf("%d blockquotes in %q, want 3", got, out)
+	}
+	if _, err := New(Options{}).TryProcess(text); err != nil {
+		t.Errorf("err = %v with the default depth", err)
+	}
+
+	/* The default keeps the stack small */
+	out, err = New(Options{}).TryProcess([]byte(strings.Repeat("> ", 100000)))
+	if !errors.Is(err, ErrLimit) || strings.Count(string(out), "<blockquote>") != DefaultMaxDepth {
+		t.Errorf("err = %v, %d blockquotes", err, strings.Count(string(out), "<blockquote>"))
+	}
+}
+
+func TestMaxSize(t *testing.T) {
+	out, err := New(Options{MaxSize: 7}).TryProcess([]byte("*a* bcé"))
+	if want := "<p><em>a</em> bc</p>\n"; string(out) != want || !errors.Is(err, ErrLimit) {
+		t.Errorf("got %q, %v, want %q, ErrLimit", out, err, want)
+	}
+}
+
+func TestMaxSteps(t *testing.T) {
+	r := New(Options{MaxSteps: 3})
+	out, err := r.TryProcess([]byte("*a* *b* *c*"))
+	if want := "<p><em>a</em> *b* *c*</p>\n"; string(out) != want || !errors.Is(err, ErrLimit) {
+		t.Errorf("got %q, %v, want %q, ErrLimit", out, err, want)
+	}
+	if err := r.Render(&strings.Builder{}, strings.NewReader("a b c d")); !errors.Is(err, ErrLimit) {
+		t.Errorf("Render = %v, want ErrLimit", err)
+	}
+
+	/* The budget is per document */
+	out, err = r.TryProcess([]byte("*a*"))
+	if want := "<p><em>a</em></p>\n"; string(out) != want || err != nil {
+		t.Errorf("got %q, %v, want %q", out, err, want)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	text := []byte(strings.Repeat("*a* ", 100000))
+	start := time.Now()
+	_, err := New(Options{Timeout: time.Millisecond}).TryProcess(text)
+	if !errors.Is(err, ErrLimit) {
+		t.Errorf("err = %v, want ErrLimit", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("took %v", d)
+	}
+}
+
+// TestCommonMarkLimits checks that the limits also apply to the
+// CommonMark parser.
+func TestCommonMarkLimits(t *testing.T) {
+	tests := []struct {
+		opts       Options
+		text, want string
+	}{
+		{Options{MaxDepth: 3}, "> > > > > deep",
+			"<blockquote>\n<blockquote>\n<blockquote>\n<p>&gt; &gt; deep</p>\n</blockquote>\n</blockquote>\n</blockquote>\n"},
+		{Options{MaxDepth: 3}, "***a*** **_*b*_**",
+			"<p><em><strong>a</strong></em> <strong><em>b</em></strong></p>\n"},
+		{Options{MaxSteps: 3}, "*a* *b* *c*", "<p>*a* *b* *c*</p>\n"},
+		{Options{MaxSteps: 3}, "a\nb\n\n*c*\nd", "<p>a\nb</p>\n<p>*c*\nd</p>\n"},
+	}
+	for _, tt := range tests {
+		tt.opts.CommonMark = true
+		out, err := New(tt.opts).TryProcess([]byte(tt.text))
+		if string(out) != tt.want || !errors.Is(err, ErrLimit) {
+			t.Errorf("%q:\ngot  %q, %v\nwant %q, ErrLimit", tt.text, out, err, tt.want)
+		}
+	}
+
+	text := []byte(strings.Repeat("*a* ", 100000))
+	start := time.Now()
+	_, err := New(Options{CommonMark: true, Timeout: time.Millisecond}).TryProcess(text)
+	if !errors.Is(err, ErrLimit) {
+		t.Errorf("err = %v, want ErrLimit", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("took %v", d)
+	}
+}
+
+// TestScanCache checks that cached scans of one list item are not used
+// for the next one.
+func TestScanCache(t *testing.T) {
+	tests := []struct{ text, want string }{
+		{"- [a](b) x\n- [a] xxxx\n- end\n",
+			"<ul>\n<li><a href=\"b\">a</a> x</li>\n<li>[a] xxxx</li>\n<li>end</li>\n</ul>\n"},
+		{"- *a* bc\n- *a  bc\n- z\n",
+			"<ul>\n<li><em>a</em> bc</li>\n<li>*a  bc</li>\n<li>z</li>\n</ul>\n"},
+	}
+	for _, tt := range tests {
+		if got := string(New(Options{}).Process([]byte(tt.text))); got != tt.want {
+			t.Errorf("%q:\ngot  %q\nwant %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+// TestShortLink checks that the cached scans of doshortlink find the same
+// addresses as scanning up to the first '>'.
+func TestShortLink(t *testing.T) {
+	tests := []struct{ text, want string }{
+		{"<<http://y>", "<p><a href=\"&lt;http://y\">&lt;http://y</a></p>\n"},
+		{"<a b> <a:b> <ab>", "<p><a b> <a href=\"a:b\">a:b</a> <ab></p>\n"},
+		{"<a@b c> <a@b#c>", "<p><a@b c> <a href=\"a@b#c\">a@b#c</a></p>\n"},
+	}
+	for _, tt := range tests {
+		if got := string(New(Options{}).Process([]byte(tt.text))); got != tt.want {
+			t.Errorf("%q:\ngot  %q\nwant %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+/* pathological are inputs that took quadratic time, by their unit, in
+ * the dialects by name */
+var pathological = map[string][]string{
+	"default":  {"*", "[", "![", "[^", "[a]", "[a](", "<", "<a", "<a>", "> ", "<!--"},
+	"autolink": {"_a", "a@", "www.", "http://", "a.", "(a"},
+	"commonmark": {"*", "*a", "a*", "_a", "a_", "**a", "[", "![", "[a]", "[a](", "](",
+		"[a](b (", "<", "<a", "<!--", "<?", "<!a", "<![CDATA[", "`", "``a", "`a", "> ",
+		"- ", "&", "\\"},
+}
+
+var pathologicalOptions = map[string]Options{
+	"default":    {},
+	"autolink":   {Autolink: true},
+	"commonmark": {CommonMark: true},
+}
+
+// BenchmarkPathological renders n units of each pathological input. The
+// time per byte stays the same as n grows.
+func BenchmarkPathological(b *testing.B) {
+	for dialect, units := range pathological {
+		for _, unit := range units {
+			for _, n := range []int{12500, 25000, 50000} {
+				text := []byte(strings.Repeat(unit, n))
+				b.Run(fmt.Sprintf("%s/%q/%d", dialect, unit, n), func(b *testing.B) {
+					b.SetBytes(int64(len(text)))
+					r := New(pathologicalOptions[dialect])
+					for b.Loop() {
+						r.Process(text)
+					}
+				})
+			}
+		}
+	}
+}
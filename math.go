@@ -0,0 +1,124 @@
+package smu
+
+import (
+	"bytes"
+	"io"
+)
+
+// MathRenderer writes a TeX formula as HTML. display is set for formulas
+// written between "$$", which are shown on a line of their own.
+type MathRenderer interface {
+	RenderMath(w io.Writer, tex []byte, display bool) error
+}
+
+// MathRendererFunc adapts a function to the MathRenderer interface.
+type MathRendererFunc func(w io.Writer, tex []byte, display bool) error
+
+func (f MathRendererFunc) RenderMath(w io.Writer, tex []byte, display bool) error {
+	return f(w, tex, display)
+}
+
+// SetMathRenderer makes the renderer write formulas with m instead of
+// leaving the escaped TeX to a script on the page. Math must be enabled
+// in the options.
+func (r *Renderer) SetMathRenderer(m MathRenderer) {
+	r.math = m
+}
+
+/* domathblock handles display formulas that start a block */
+func (r *Renderer) domathblock(text []byte, newblock bool) int {
+	begin := 0
+
+	if !r.opts.Math || !newblock || !hasprefix(text[begin:], "$$") {
+		return 0
+	}
+	start := begin + 2
+	stop := mathend(text[start:], "$$")
+	if stop == -1 {
+		return 0
+	}
+	stop += start
+	/* Nothing may follow the formula on its last line */
+	eol := lineend(text, stop+2)
+	if len(bytes.TrimSpace(text[stop+2:eol])) > 0 {
+		return 0
+	}
+
+	r.EndParagraph()
+	n := r.AddNode(NewNode(MathBlock))
+	n.Literal = append([]byte(nil), bytes.TrimSpace(text[start:stop])...)
+	return -(eol - begin)
+}
+
+/* domath handles inline formulas in "$" and display formulas in "$$" */
+func (r *Renderer) domath(text []byte, newblock bool) int {
+	begin, end := 0, len(text)
+
+	if !r.opts.Math || text[begin] != '$' {
+		return 0
+	}
+	n := NewNode(Math)
+	delim := "$"
+	if hasprefix(text[begin:], "$$") {
+		n.Display = true
+		delim = "$$"
+	}
+	start := begin + len(delim)
+	stop := mathend(text[start:], delim)
+	if stop <= 0 {
+		return 0
+	}
+	stop += start
+	/* Inline formulas must not start or end with a space and are not
+	 * followed by a digit, so that amounts like $5 and $10 stay text */
+	if !n.Display {
+		if isSpace(text[start]) || isSpace(text[stop-1]) ||
+			(stop+1 < end && isDigit(text[stop+1])) {
+			return 0
+		}
+	}
+
+	r.AddNode(n)
+	n.Literal = append([]byte(nil), bytes.TrimSpace(text[start:stop])...)
+	return stop + len(delim) - begin
+}
+
+/* mathend returns the position of the unescaped delim closing a formula,
+ * or -1 */
+func mathend(text []byte, delim string) int {
+	for p := 0; p < len(text); p++ {
+		switch {
+		case text[p] == '\\':
+			p++
+		case hasprefix(text[p:], delim):
+			return p
+		}
+	}
+	return -1
+}
+
+/* mathhtml writes the formula n */
+func (r *Renderer) mathhtml(n *Node, display bool) {
+	if r.math != nil {
+		if err := r.math.RenderMath(&r.out, n.Literal, display); err != nil && r.err == nil {
+			r.err = err
+		}
+		return
+	}
+	switch {
+	case n.Type == MathBlock:
+		r.out.WriteString("<div class=\"math display\"")
+		r.sourcepos(n)
+		r.out.WriteString(">")
+	case display:
+		r.out.WriteString("<span class=\"math display\">")
+	default:
+		r.out.WriteString("<span class=\"math inline\">")
+	}
+	r.tprint(n.Literal)
+	if n.Type == MathBlock {
+		r.out.WriteString("</div>")
+	} else {
+		r.out.WriteString("</span>")
+	}
+}
@@ -0,0 +1,56 @@
+package smu
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestMath(t *testing.T) {
+	tests := []struct{ text, want string }{
+		{"a $x^2 < y$ b", "<p>a <span class=\"math inline\">x^2 &lt; y</span> b</p>\n"},
+		{"a $$x$$ b", "<p>a <span class=\"math display\">x</span> b</p>\n"},
+		{"$$\n\\sum_i a_i\n$$\n", "<div class=\"math display\">\\sum_i a_i</div>\n"},
+		{"$$x$$ trailing\n", "<p><span class=\"math display\">x</span> trailing</p>\n"},
+		{"`$x$` $*a*$", "<p><code>$x$</code> <span class=\"math inline\">*a*</span></p>\n"},
+
+		/* Escaped dollars are text, also in formulas */
+		{`\$x$ and $a\$b$`, "<p>$x$ and <span class=\"math inline\">a\\$b</span></p>\n"},
+
+		/* Amounts, spaces inside the dollars and open formulas are text */
+		{"costs $5 and $10", "<p>costs $5 and $10</p>\n"},
+		{"$ x$ $x $ $x$5", "<p>$ x$ $x $ $x$5</p>\n"},
+		{"$x", "<p>$x</p>\n"},
+		{"$$\nunclosed\n", "<p>$$\nunclosed</p>\n"},
+	}
+	for _, tt := range tests {
+		if got := string(New(Options{Math: true}).Process([]byte(tt.text))); got != tt.want {
+			t.Errorf("%q:\ngot  %q\nwant %q", tt.text, got, tt.want)
+		}
+	}
+
+	/* Without Math the dollars are text */
+	if got, want := string(New(Options{}).Process([]byte(`$x$ \$`))), "<p>$x$ $</p>\n"; got != want {
+		t.Errorf("without Math:\ngot  %q\nwant %q", got, want)
+	}
+}
+
+func TestMathRenderer(t *testing.T) {
+	r := New(Options{Math: true})
+	r.SetMathRenderer(MathRendererFunc(func(w io.Writer, tex []byte, display bool) error {
+		if string(tex) == "fail" {
+			return errors.New("fail")
+		}
+		_, err := fmt.Fprintf(w, "[%s %v]", tex, display)
+		return err
+	}))
+	text := []byte("$a<b$ $$c$$\n\n$$\nd\n$$\n")
+	if got, want := string(r.Process(text)), "<p>[a<b false] [c true]</p>\n[d true]\n"; got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+	if err := r.Render(&strings.Builder{}, strings.NewReader("$fail$")); err == nil || err.Error() != "fail" {
+		t.Errorf("Render = %v, want fail", err)
+	}
+}
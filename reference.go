@@ -0,0 +1,176 @@
+package smu
+
+import (
+	"bytes"
+	"strings"
+)
+
+/* linkref is a link reference definition "[label]: dest "title"" */
+type linkref struct {
+	dest, title string
+}
+
+/* definitions collects the footnote and link reference definitions of text
+ * and returns text without them. Every removed line is replaced by an empty
+ * line so that line numbers stay the same. Definitions start a block and
+ * are not recognised inside fenced code. The first definition of a label
+ * wins. */
+func (r *Renderer) definitions(text []byte) []byte {
+	var out []byte
+	var fence []byte
+	last := 0
+	blank := true
+	for p := 0; p < len(text); {
+		eol := lineend(text, p)
+		line := text[p:eol]
+		switch {
+		case fence != nil:
+			if isclosingfence(bytes.TrimRight(line, "\n"), fence[0], len(fence)) {
+				fence = nil
+			}
+		case openfence(line) != nil:
+			fence = openfence(line)
+		case blank:
+			next := 0
+			if label, body, end := footnotedef(text, p); end != 0 {
+				if _, ok := r.footnotes[label]; !ok {
+					r.footnotes[label] = &footnote{body: body}
+				}
+				next = end
+			} else if label, ref, end := linkdef(text, p); end != 0 {
+				if _, ok := r.refs[label]; !ok {
+					r.refs[label] = ref
+				}
+				next = end
+			}
+			if next == 0 {
+				break
+			}
+			out = append(out, text[last:p]...)
+			out = append(out, bytes.Repeat([]byte("\n"), bytes.Count(text[p:next], []byte("\n")))...)
+			last, p = next, next
+			continue
+		}
+		blank = len(bytes.TrimSpace(line)) == 0
+		p = eol
+	}
+	if out == nil {
+		return text
+	}
+	return append(out, text[last:]...)
+}
+
+/* linkdef reads the link reference definition starting at p. The title
+ * may be given on the following line. It returns the normalised label, the
+ * definition and its end, which is 0 if there is none. */
+func linkdef(text []byte, p int) (string, linkref, int) {
+	var ref linkref
+	for i := 0; i < 3 && p < len(text) && text[p] == ' '; i++ {
+		p++
+	}
+	if p == len(text) || text[p] != '[' || bytes.HasPrefix(text[p:], []byte("[^")) {
+		return "", ref, 0
+	}
+	stop := bytes.IndexAny(text[p+1:], "[]")
+	if stop == -1 || text[p+1+stop] != ']' {
+		return "", ref, 0
+	}
+	label := reflabel(text[p+1 : p+1+stop])
+	p += stop + 2
+	if label == "" || p == len(text) || text[p] != ':' {
+		return "", ref, 0
+	}
+	p++
+	for p < len(text) && isSpace(text[p]) {
+		p++
+	}
+
+	/* Destination, optionally in angular brackets */
+	start := p
+	if p < len(text) && text[p] == '<' {
+		i := bytes.IndexAny(text[p:], ">\n")
+		if i == -1 || text[p+i] != '>' {
+			return "", ref, 0
+		}
+		ref.dest = string(text[p+1 : p+i])
+		p += i + 1
+	} else {
+		for p < len(text) && !isSpace(text[p]) && text[p] != '\n' {
+			p++
+		}
+		if p == start {
+			return "", ref, 0
+		}
+		ref.dest = string(text[start:p])
+	}
+
+	eol := lineend(text, p)
+	rest := bytes.TrimSpace(text[p:eol])
+	if len(rest) > 0 {
+		if p == start || !isSpace(text[p]) {
+			return "", ref, 0
+		}
+		title, ok := reftitle(rest)
+		if !ok {
+			return "", ref, 0
+		}
+		ref.title = title
+		return label, ref, eol
+	}
+	if next := lineend(text, eol); eol < len(text) {
+		if title, ok := reftitle(bytes.TrimSpace(text[eol:next])); ok {
+			ref.title = title
+			return label, ref, next
+		}
+	}
+	return label, ref, eol
+}
+
+/* reftitle returns the title of a definition written in double or single
+ * quotes or in parentheses */
+func reftitle(text []byte) (string, bool) {
+	if len(text) < 2 {
+		return "", false
+	}
+	open, stop := text[0], text[len(text)-1]
+	if !(open == '"' && stop == '"') && !(open == '\'' && stop == '\'') && !(open == '(' && stop == ')') {
+		return "", false
+	}
+	return string(text[1 : len(text)-1]), true
+}
+
+/* reflabel normalises a link label, which is matched case-insensitively
+ * and with runs of white space collapsed */
+func reflabel(label []byte) string {
+	return strings.ToLower(strings.Join(strings.Fields(string(label)), " "))
+}
+
+/* reflink handles the reference links [text][ref], [text][] and [ref],
+ * and the images ![alt][ref], ![alt][] and ![alt], whose description
+ * starts at desc. It returns the number of bytes consumed or 0 if text is
+ * not a link to a defined reference. */
+func (r *Renderer) reflink(text []byte, desc int, img bool) int {
+	if len(r.refs) == 0 {
+		return 0
+	}
+	descend := r.closing(text, desc-1, '[', ']', true)
+	if descend == -1 {
+		return 0
+	}
+	label := text[desc:descend]
+	l := descend + 1
+	if l < len(text) && text[l] == '[' {
+		if stop := r.index(text[l:], "]"); stop != -1 {
+			if stop > 1 {
+				label = text[l+1 : l+stop]
+			}
+			l += stop + 1
+		}
+	}
+	ref, ok := r.refs[reflabel(label)]
+	if !ok {
+		return 0
+	}
+	r.addlink(text[desc:descend], ref.dest, ref.title, img)
+	return l
+}
@@ -0,0 +1,55 @@
+package smu
+
+import "testing"
+
+// TestReferenceLinks checks reference links in both dialects. cm is the
+// output with Options.CommonMark if it differs.
+func TestReferenceLinks(t *testing.T) {
+	tests := []struct{ text, want, cm string }{
+		{"[a][r] [b][] [r] ![i][r]\n\n[r]: /u \"t\"\n[b]: /b\n",
+			"<p><a href=\"/u\" title=\"t\">a</a> <a href=\"/b\">b</a> <a href=\"/u\" title=\"t\">r</a> <img src=\"/u\" alt=\"i\" title=\"t\" /></p>\n", ""},
+
+		/* Labels are matched case-insensitively with their white space
+		 * collapsed, the first definition of a label is used */
+		{"[a][R]\n\n[r]: /u\n", "<p><a href=\"/u\">a</a></p>\n", ""},
+		{"[Foo  Bar]\n\n[foo bar]: /u\n", "<p><a href=\"/u\">Foo  Bar</a></p>\n", ""},
+		{"[r]: /1\n[r]: /2\n\n[r]\n", "<p><a href=\"/1\">r</a></p>\n", ""},
+		{"[*em* r]\n\n[*em* r]: /u\n", "<p><a href=\"/u\"><em>em</em> r</a></p>\n", ""},
+
+		/* Titles */
+		{"[r]: /u 'single'\n[s]: </a b> (paren)\n\n[r] [s]\n",
+			"<p><a href=\"/u\" title=\"single\">r</a> <a href=\"/a b\" title=\"paren\">s</a></p>\n",
+			"<p><a href=\"/u\" title=\"single\">r</a> <a href=\"/a%20b\" title=\"paren\">s</a></p>\n"},
+		{"[r]: /u\n  \"title on next line\"\n\n[r]\n", "<p><a href=\"/u\" title=\"title on next line\">r</a></p>\n", ""},
+
+		/* Inline links and images come first, references are used if
+		 * they are not complete */
+		{"[foo](http://inline) ![x](img.png) [foo] [x](\n\n[foo]: /ref\n[x]: /x\n",
+			"<p><a href=\"http://inline\">foo</a> <img src=\"img.png\" alt=\"x\" /> <a href=\"/ref\">foo</a> <a href=\"/x\">x</a>(</p>\n", ""},
+		{"[foo] and [b](c)\n\n[foo]: /ref\n", "<p><a href=\"/ref\">foo</a> and <a href=\"c\">b</a></p>\n", ""},
+
+		/* Undefined references and escaped brackets are text */
+		{"[a][x] [y] [z][]\n", "<p>[a][x] [y] [z][]</p>\n", ""},
+		{"[a] [b]\n\n[a]: /a\n", "<p><a href=\"/a\">a</a> [b]</p>\n", ""},
+		{"\\[r] [r\\]\n\n[r]: /u\n", "<p>[r] [r]</p>\n", ""},
+
+		/* Only whole definitions outside paragraphs and code are removed */
+		{"[a][r]\n\n[r]: /u \"t\" junk\n", "<p>[a][r]</p>\n<p>[r]: /u \"t\" junk</p>\n",
+			"<p>[a][r]</p>\n<p>[r]: /u &quot;t&quot; junk</p>\n"},
+		{"para\n[r]: /u\n\n[r]\n", "<p>para\n[r]: /u</p>\n<p>[r]</p>\n", ""},
+		{"    [r]: /u\n\n[r]\n", "<pre><code>[r]: /u\n\n</code></pre>\n<p>[r]</p>\n",
+			"<pre><code>[r]: /u\n</code></pre>\n<p>[r]</p>\n"},
+	}
+	for _, tt := range tests {
+		if got := string(New(Options{}).Process([]byte(tt.text))); got != tt.want {
+			t.Errorf("%q:\ngot  %q\nwant %q", tt.text, got, tt.want)
+		}
+		want := tt.cm
+		if want == "" {
+			want = tt.want
+		}
+		if got := string(New(Options{CommonMark: true}).Process([]byte(tt.text))); got != want {
+			t.Errorf("CommonMark %q:\ngot  %q\nwant %q", tt.text, got, want)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package smu
+
+import (
+	"bytes"
+	"html"
+	"slices"
+	"strings"
+)
+
+// DefaultSchemes are the URL schemes allowed in safe mode when
+// Options.AllowedSchemes is nil. Relative URLs are always allowed.
+var DefaultSchemes = []string{"http", "https", "mailto", "ftp"}
+
+// DefaultTags are the HTML elements, with their allowed attributes, that
+// are kept in safe mode when Options.AllowedTags is nil.
+var DefaultTags = map[string][]string{
+	"a":          {"href", "title"},
+	"abbr":       {"title"},
+	"b":          nil,
+	"blockquote": {"cite"},
+	"br":         nil,
+	"center":     nil,
+	"code":       nil,
+	"dd":         nil,
+	"del":        nil,
+	"div":        nil,
+	"dl":         nil,
+	"dt":         nil,
+	"em":         nil,
+	"hr":         nil,
+	"i":          nil,
+	"img":        {"src", "alt", "title", "width", "height"},
+	"ins":        nil,
+	"kbd":        nil,
+	"li":         nil,
+	"mark":       nil,
+	"ol":         {"start"},
+	"p":          nil,
+	"pre":        nil,
+	"q":          {"cite"},
+	"s":          nil,
+	"span":       nil,
+	"strong":     nil,
+	"sub":        nil,
+	"sup":        nil,
+	"table":      nil,
+	"tbody":      nil,
+	"td":         nil,
+	"th":         nil,
+	"thead":      nil,
+	"tr":         nil,
+	"u":          nil,
+	"ul":         nil,
+}
+
+/* attributes whose value is a URL */
+var urlAttrs = []string{"href", "src", "cite"}
+
+/* unsafeURL replaces destinations with a scheme that is not allowed */
+const unsafeURL = "#"
+
+/* safeurl reports whether dest may be written in safe mode */
+func (r *Renderer) safeurl(dest string) bool {
+	/* Browsers ignore whitespace and control characters in schemes */
+	u := strings.Map(func(c rune) rune {
+		if c <= ' ' || c == 0x7f {
+			return -1
+		}
+		return c
+	}, dest)
+	i := strings.IndexAny(u, ":/?#")
+	if i <= 0 || u[i] != ':' {
+		return true
+	}
+	schemes := r.opts.AllowedSchemes
+	if schemes == nil {
+		schemes = DefaultSchemes
+	}
+	return slices.Contains(schemes, strings.ToLower(u[:i]))
+}
+
+/* linkdest returns dest, neutralized if it is not safe */
+func (r *Renderer) linkdest(dest string) string {
+	if r.opts.Safe && !r.safeurl(dest) {
+		return unsafeURL
+	}
+	return dest
+}
+
+type htmlTag struct {
+	name      string
+	attrs     []Attr
+	closing   bool
+	selfclose bool
+}
+
+/* sanitize writes raw HTML keeping only allowed elements and attributes.
+ * Comments are dropped, everything else is escaped and shows up as text. */
+func (r *Renderer) sanitize(raw []byte) {
+	allowed := r.opts.AllowedTags
+	if allowed == nil {
+		allowed = DefaultTags
+	}
+	for len(raw) > 0 {
+		i := bytes.IndexByte(raw, '<')
+		if i == -1 {
+			r.out.Write(raw)
+			return
+		}
+		r.out.Write(raw[:i])
+		raw = raw[i:]
+
+		/* "<!-->" and "<!--->" are comments too */
+		if hasprefix(raw, "<!--") {
+			if j := bytes.Index(raw[2:], []byte("-->")); j != -1 {
+				raw = raw[2+j+3:]
+				continue
+			}
+		}
+		l, tag := parsetag(raw)
+		if l == 0 {
+			r.out.WriteString("&lt;")
+			raw = raw[1:]
+			continue
+		}
+		attrs, ok := allowed[tag.name]
+		if !ok {
+			r.tprint(raw[:l])
+			raw = raw[l:]
+			continue
+		}
+
+		r.out.WriteString("<")
+		if tag.closing {
+			r.out.WriteString("/")
+		}
+		r.out.WriteString(tag.name)
+		for _, a := range tag.attrs {
+			if !slices.Contains(attrs, a.Key) {
+				continue
+			}
+			if slices.Contains(urlAttrs, a.Key) && !r.safeurl(a.Value) {
+				a.Value = unsafeURL
+			}
+			r.out.WriteString(" ")
+			r.attr(a)
+		}
+		if tag.selfclose {
+			r.out.WriteString(" /")
+		}
+		r.out.WriteString(">")
+		raw = raw[l:]
+	}
+}
+
+/* parsetag reads the tag at the start of text and returns its length, or 0
+ * if text does not start with a well-formed tag. Names are lower cased and
+ * attribute values unescaped. */
+func parsetag(text []byte) (int, htmlTag) {
+	var tag htmlTag
+	end := len(text)
+	p := 1
+	if p < end && text[p] == '/' {
+		tag.closing = true
+		p++
+	}
+	start := p
+	for p < end && (isAlnum(text[p]) || (p > start && text[p] == '-')) {
+		p++
+	}
+	if p == start || !isAlpha(text[start]) {
+		return 0, tag
+	}
+	tag.name = strings.ToLower(string(text[start:p]))
+
+	for p < end {
+		for p < end && isHTMLSpace(text[p]) {
+			p++
+		}
+		if p == end {
+			break
+		}
+		switch {
+		case text[p] == '>':
+			return p + 1, tag
+		case text[p] == '/' && p+1 < end && text[p+1] == '>':
+			tag.selfclose = true
+			return p + 2, tag
+		case tag.closing:
+			return 0, tag
+		}
+
+		/* attribute name */
+		start = p
+		for p < end && !isHTMLSpace(text[p]) && text[p] != '=' && text[p] != '>' && text[p] != '/' {
+			p++
+		}
+		if p == start {
+			return 0, tag
+		}
+		a := Attr{Key: strings.ToLower(string(text[start:p]))}
+
+		/* optional value */
+		q := p
+		for q < end && isHTMLSpace(text[q]) {
+			q++
+		}
+		if q < end && text[q] == '=' {
+			p = q + 1
+			for p < end && isHTMLSpace(text[p]) {
+				p++
+			}
+			if p == end {
+				return 0, tag
+			}
+			if text[p] == '"' || text[p] == '\'' {
+				i := bytes.IndexByte(text[p+1:], text[p])
+				if i == -1 {
+					return 0, tag
+				}
+				a.Value = string(text[p+1 : p+1+i])
+				p += i + 2
+			} else {
+				start = p
+				for p < end && !isHTMLSpace(text[p]) && text[p] != '>' {
+					p++
+				}
+				a.Value = string(text[start:p])
+			}
+			a.Value = html.UnescapeString(a.Value)
+		}
+		tag.attrs = append(tag.attrs, a)
+	}
+	return 0, tag
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
@@ -0,0 +1,59 @@
+package smu
+
+import "testing"
+
+// TestSafe checks the output of untrusted input in both dialects. cm is
+// the output with Options.CommonMark if it differs.
+func TestSafe(t *testing.T) {
+	tests := []struct{ name, text, want, cm string }{
+		{"attributes", `<b onclick="x()">a</b> <div class="c" style="s">d</div>`,
+			"<p><b>a</b> <div>d</div></p>\n", ""},
+		{"tag case", `<ABBR TITLE="t">a</ABBR>`,
+			"<p><abbr title=\"t\">a</abbr></p>\n", ""},
+		{"tags", "<script>alert(1)</script> <iframe src=x></iframe>",
+			"<p>&lt;script&gt;alert(1)&lt;/script&gt; &lt;iframe src=x&gt;&lt;/iframe&gt;</p>\n",
+			"&lt;script&gt;alert(1)&lt;/script&gt; &lt;iframe src=x&gt;&lt;/iframe&gt;\n"},
+		{"broken tag", `a <a href="x" <b`,
+			"<p>a &lt;a href=\"x\" &lt;b</p>\n", "<p>a &lt;a href=&quot;x&quot; &lt;b</p>\n"},
+		{"raw urls", `<a href="javascript:alert(1)" title="t">x</a> <a href=" JaVa&#115;cript:x">y</a>`,
+			"<p><a href=\"#\" title=\"t\">x</a> <a href=\"#\">y</a></p>\n", ""},
+		{"raw image", `<img src="data:x" alt="a" onerror="x">`,
+			"<p><img src=\"#\" alt=\"a\"></p>\n", "<img src=\"#\" alt=\"a\">\n"},
+		{"link urls", "[a](javascript:alert(1)) [b](data:text/html,x) [c](/rel) [d](https://x) [e](mailto:a@b)",
+			"<p><a href=\"#\">a</a> <a href=\"#\">b</a> <a href=\"/rel\">c</a> <a href=\"https://x\">d</a> <a href=\"mailto:a@b\">e</a></p>\n", ""},
+		{"image url", "![i](data:image/png;base64,x)",
+			"<p><img src=\"#\" alt=\"i\" /></p>\n", ""},
+		{"comments", "a <!-- c --> b <!--> c <!---> d <!-- e",
+			"<p>a  b  c  d &lt;!-- e</p>\n", ""},
+		{"comment block", "<!-- c -->\n\n<b>x</b>",
+			"<p><b>x</b></p>\n", ""},
+		{"html in link", "[<img src=x onerror=y>](z)",
+			"<p><a href=\"z\"><img src=\"x\"></a></p>\n", ""},
+		{"html in image", "![<b onclick=x>a</b>](y.png)",
+			"<p><img src=\"y.png\" alt=\"&lt;b onclick=x&gt;a&lt;/b&gt;\" /></p>\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(New(Options{Safe: true}).Process([]byte(tt.text))); got != tt.want {
+				t.Errorf("got  %q\nwant %q", got, tt.want)
+			}
+			want := tt.cm
+			if want == "" {
+				want = tt.want
+			}
+			if got := string(New(Options{Safe: true, CommonMark: true}).Process([]byte(tt.text))); got != want {
+				t.Errorf("CommonMark:\ngot  %q\nwant %q", got, want)
+			}
+		})
+	}
+}
+
+func TestSafeAllowed(t *testing.T) {
+	r := New(Options{Safe: true, AllowedSchemes: []string{"data"},
+		AllowedTags: map[string][]string{"span": {"class"}}})
+	text := []byte(`[a](data:x) [b](https://x) <span class="c" id="d">e</span> <b>f</b>`)
+	want := "<p><a href=\"data:x\">a</a> <a href=\"#\">b</a> <span class=\"c\">e</span> &lt;b&gt;f&lt;/b&gt;</p>\n"
+	if got := string(r.Process(text)); got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+}
@@ -3,10 +3,13 @@ package smu
 import (
 	"bytes"
 	"fmt"
-	"regexp"
+	"io"
+	"runtime/debug"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 	"unicode"
-	"unicode/utf8"
 )
 
 const (
@@ -14,65 +17,240 @@ const (
 	VERSION     = "1.0"
 	codeFence   = "```"
 	htmlComment = "<!--"
+	hardBreak   = "  \n"
 )
 
+// Tag describes markup that is recognised by its delimiter, such as the
+// surrounding "**" of strong text or the "> " prefix of a blockquote.
 type Tag struct {
 	search  string
 	process int
-	before  string
-	after   string
+	node    NodeType
+	level   int /* heading level or number of emphasis delimiters */
+	element string
+	class   string
 }
 
-type Parser func(text []byte, newblock bool) (affected int)
+// NewTag returns a Tag for custom markup starting with search. The content
+// is kept as is if process is 0 and parsed as inline markup otherwise. The
+// lines of a line prefix are parsed as blocks if process is 2. It is
+// rendered as the HTML element with the given class, which may be empty.
+func NewTag(search string, process int, element, class string) Tag {
+	return Tag{search: search, process: process, element: element, class: class}
+}
+
+/* newnode returns an empty node for markup matched by t */
+func (t Tag) newnode() *Node {
+	n := NewNode(t.node)
+	n.Level = t.level
+	n.Element = t.element
+	n.Class = t.class
+	return n
+}
+
+// Parser is a single step of the chain of responsibility that turns
+// smu markup into a document tree. It inspects text, which starts at the
+// current position, adds nodes through r and reports how many bytes it
+// consumed. A negative result means the parser handled a whole block.
+// newblock is set when text starts a new block. Returning 0 passes text on
+// to the next parser.
+type Parser func(r *Renderer, text []byte, newblock bool) (affected int)
+
+// Options configures a Renderer.
+type Options struct {
+	// NoHTML disables inline HTML and HTML comments.
+	NoHTML bool
+
+	// Safe prepares the output of untrusted input: link and image
+	// destinations with a scheme not in AllowedSchemes are replaced by
+	// "#", inline HTML is reduced to the elements and attributes in
+	// AllowedTags and HTML comments are dropped.
+	Safe bool
+
+	// AllowedSchemes are the URL schemes allowed in safe mode. Nil means
+	// DefaultSchemes.
+	AllowedSchemes []string
+
+	// AllowedTags maps the HTML elements allowed in safe mode to their
+	// allowed attributes. Nil means DefaultTags.
+	AllowedTags map[string][]string
+
+	// HeadingIDs writes the unique id of every heading, see TOC.
+	HeadingIDs bool
+
+	// HeadingAnchors writes heading ids and appends a link to the
+	// heading itself.
+	HeadingAnchors bool
+
+	// Highlight enables the built-in syntax highlighting of fenced code
+	// blocks in Go, shell, JSON, YAML, C, Python and diffs. The classes
+	// used are styled by HighlightCSS.
+	Highlight bool
+
+	// Strikethrough enables ~~deleted~~ text.
+	Strikethrough bool
+
+	// Mark enables ==highlighted== text.
+	Mark bool
+
+	// Superscript enables ^superscript^ text.
+	Superscript bool
+
+	// Subscript enables ~subscript~ text.
+	Subscript bool
+
+	// Admonitions maps the kinds of admonitions, written as "> [!KIND]"
+	// blockquotes or ":::kind" containers, to their default titles. Nil
+	// means DefaultAdmonitions. Admonitions without a title have no title
+	// paragraph.
+	Admonitions map[string]string
+
+	// Math enables TeX formulas in "$" and "$$", see SetMathRenderer.
+	Math bool
+
+	// Autolink turns bare URLs starting with http://, https://, ftp:// or
+	// www. and bare mail addresses into links.
+	Autolink bool
+
+	// ObfuscateEmail writes the bare mail addresses found by Autolink as
+	// character references, like addresses in angular brackets.
+	ObfuscateEmail bool
+
+	// SourcePos records the source lines and columns of blocks in
+	// Node.Pos and writes them as data-sourcepos="3:1-5:12" attributes.
+	SourcePos bool
+
+	// CommonMark parses text by the CommonMark specification instead of
+	// smu's rules and renders it like the reference implementation. The
+	// extensions above, custom parsers and tags do not apply; HTML,
+	// safe mode, heading ids, highlighting, hooks and SourcePos do.
+	CommonMark bool
+
+	// MaxDepth limits the nesting of blocks and inline markup, deeper
+	// markup is plain text. 0 means DefaultMaxDepth.
+	MaxDepth int
+
+	// MaxSize limits the input in bytes, the rest is ignored. 0 means no
+	// limit.
+	MaxSize int
+
+	// MaxSteps limits the steps of the parser, about one per markup
+	// element or character of text. After that, the rest of the input is
+	// plain text. 0 means no limit.
+	MaxSteps int
+
+	// Timeout limits the time spent parsing, like MaxSteps. 0 means no
+	// limit.
+	Timeout time.Duration
+}
+
+// Priorities of the built-in parsers. Parsers run in ascending order of
+// priority; see Renderer.AddParser.
+const (
+	PriorityUnderline = 100 * (iota + 1)
+	PriorityComment
+	PriorityCodeFence
+	PriorityLinePrefix
+	PriorityList
+	PriorityTable
+	PriorityParagraph
+	PrioritySurround
+	PriorityLink
+	PriorityShortLink
+	PriorityHTML
+	PriorityReplace
+)
+
+type parserEntry struct {
+	parse    Parser
+	priority int
+	builtin  bool
+	starts   func(r *Renderer) string /* bytes the markup starts with inside a block, all if nil */
+}
+
+// Renderer parses smu markup and renders it to HTML. It owns all state of
+// a single render, so renderers can be used from different goroutines at
+// the same time. A single Renderer must not be shared between goroutines.
+type Renderer struct {
+	opts Options
+	out  bytes.Buffer
+	w    io.Writer
+	err  error
+
+	parsers     []parserEntry
+	lineprefixs []Tag
+	surrounds   []Tag
+	linkHooks   []LinkHook
+	imageHooks  []LinkHook
+
+	highlighters map[string]Highlighter
+	math         MathRenderer
+
+	footnotes map[string]*footnote
+	refs      map[string]linkref
+	fnlist    []*Node /* referenced footnotes in order */
+
+	cur  *Node /* container new nodes are added to */
+	para *Node /* open paragraph */
+
+	src      *srcmap  /* positions of the text being parsed, if enabled */
+	srclines [][]byte /* lines of the source */
+	copy     *srccopy /* copy passed to ParseInto by parsecopy */
+
+	dispatch *dispatch /* parsers by first byte, see inlineparsers */
+
+	lim limits
+}
 
 var (
-	NoHTML      bool
-	inParagraph bool
-	pEndRegex   *regexp.Regexp
-	parsers     []Parser
+	parsers     []parserEntry
 	lineprefixs []Tag
 	underlines  []Tag
 	surrounds   []Tag
+	extensions  []Tag
 	replaces    [][2]string
+	replaceIdx  [256][]int /* indices of the replaces by first byte */
 	alignTable  []string
 )
 
-var (
-	outbuffer bytes.Buffer
-)
-
 func init() {
-	pEndRegex = regexp.MustCompile("(\n\n|(^|\n)```)")
-
 	lineprefixs = []Tag{
-		{"    ", 0, "<pre><code>", "\n</code></pre>"},
-		{"\t", 0, "<pre><code>", "\n</code></pre>"},
-		{">", 2, "<blockquote>", "</blockquote>"},
-		{"###### ", 1, "<h6>", "</h6>"},
-		{"##### ", 1, "<h5>", "</h5>"},
-		{"#### ", 1, "<h4>", "</h4>"},
-		{"### ", 1, "<h3>", "</h3>"},
-		{"## ", 1, "<h2>", "</h2>"},
-		{"# ", 1, "<h1>", "</h1>"},
-		{"- - -\n", 1, "<hr />", ""},
-		{"---\n", 1, "<hr />", ""},
+		{"    ", 0, CodeBlock, 0, "", ""},
+		{"\t", 0, CodeBlock, 0, "", ""},
+		{">", 2, Blockquote, 0, "", ""},
+		{"###### ", 1, Heading, 6, "", ""},
+		{"##### ", 1, Heading, 5, "", ""},
+		{"#### ", 1, Heading, 4, "", ""},
+		{"### ", 1, Heading, 3, "", ""},
+		{"## ", 1, Heading, 2, "", ""},
+		{"# ", 1, Heading, 1, "", ""},
+		{"- - -\n", 1, HorizontalRule, 0, "", ""},
+		{"---\n", 1, HorizontalRule, 0, "", ""},
 	}
 
 	underlines = []Tag{
-		{"=", 1, "<h1>", "</h1>\n"},
-		{"-", 1, "<h2>", "</h2>\n"},
+		{"=", 1, Heading, 1, "", ""},
+		{"-", 1, Heading, 2, "", ""},
 	}
 
 	surrounds = []Tag{
-		{"```", 0, "<code>", "</code>"},
-		{"``", 0, "<code>", "</code>"},
-		{"`", 0, "<code>", "</code>"},
-		{"___", 1, "<strong><em>", "</em></strong>"},
-		{"***", 1, "<strong><em>", "</em></strong>"},
-		{"__", 1, "<strong>", "</strong>"},
-		{"**", 1, "<strong>", "</strong>"},
-		{"_", 1, "<em>", "</em>"},
-		{"*", 1, "<em>", "</em>"},
+		{"```", 0, Code, 0, "", ""},
+		{"``", 0, Code, 0, "", ""},
+		{"`", 0, Code, 0, "", ""},
+		{"___", 1, Emphasis, 3, "", ""},
+		{"***", 1, Emphasis, 3, "", ""},
+		{"__", 1, Emphasis, 2, "", ""},
+		{"**", 1, Emphasis, 2, "", ""},
+		{"_", 1, Emphasis, 1, "", ""},
+		{"*", 1, Emphasis, 1, "", ""},
+	}
+
+	/* surrounds enabled by options */
+	extensions = []Tag{
+		{"~~", 1, Strikethrough, 0, "", ""},
+		{"==", 1, Mark, 0, "", ""},
+		{"^", 1, Superscript, 0, "", ""},
+		{"~", 1, Subscript, 0, "", ""},
 	}
 
 	replaces = [][2]string{
@@ -110,26 +288,41 @@ func init() {
 		{"\\^", "^"},
 		{"\\|", "|"},
 		{"\\~", "~"},
-		{"<", "&lt;"},
-		{">", "&gt;"},
-		{"&amp;", "&amp;"},
-		{"&", "&amp;"},
-		{"  \n", "<br />\n"},
-	}
-
-	parsers = []Parser{
-		dounderline,
-		docomment,
-		docodefence,
-		dolineprefix,
-		dolist,
-		dotable,
-		doparagraph,
-		dosurround,
-		dolink,
-		doshortlink,
-		dohtml,
-		doreplace,
+		{"<", "<"},
+		{">", ">"},
+		{"&amp;", "&"},
+		{"&", "&"},
+		{hardBreak, "\n"},
+	}
+	/* doreplace only tries the replaces starting with the current byte */
+	var replaceStarts []byte
+	for i, replace := range replaces {
+		c := replace[0][0]
+		if replaceIdx[c] == nil {
+			replaceStarts = append(replaceStarts, c)
+		}
+		replaceIdx[c] = append(replaceIdx[c], i)
+	}
+
+	parsers = []parserEntry{
+		{(*Renderer).dounderline, PriorityUnderline, true, blockonly},
+		{(*Renderer).docomment, PriorityComment, true, startswith("<")},
+		{(*Renderer).docodefence, PriorityCodeFence, true, blockonly},
+		{(*Renderer).doadmonition, PriorityCodeFence, true, blockonly},
+		{(*Renderer).domathblock, PriorityCodeFence, true, blockonly},
+		{(*Renderer).dolineprefix, PriorityLinePrefix, true, startswith("\n")},
+		{(*Renderer).dolist, PriorityList, true, startswith("\n")},
+		{(*Renderer).dodeflist, PriorityList, true, blockonly},
+		{(*Renderer).dotable, PriorityTable, true, blockonly},
+		{(*Renderer).doparagraph, PriorityParagraph, true, blockonly},
+		{(*Renderer).domath, PrioritySurround, true, startswith("$")},
+		{(*Renderer).dosurround, PrioritySurround, true, (*Renderer).surroundstarts},
+		{(*Renderer).dofootnote, PriorityLink, true, startswith("[")},
+		{(*Renderer).dolink, PriorityLink, true, startswith("[!")},
+		{(*Renderer).doshortlink, PriorityShortLink, true, startswith("<")},
+		{(*Renderer).doautolink, PriorityShortLink, true, (*Renderer).autolinkstarts},
+		{(*Renderer).dohtml, PriorityHTML, true, startswith("<")},
+		{(*Renderer).doreplace, PriorityReplace, true, startswith(string(replaceStarts))},
 	}
 
 	alignTable = []string{
@@ -140,87 +333,237 @@ func init() {
 	}
 }
 
-func endParagraph() {
-	if inParagraph {
-		fmt.Fprint(&outbuffer, "</p>\n")
-		inParagraph = false
+// AddNode appends n to the node the parser currently adds to and returns
+// it. It is meant for custom parsers, like AddText, ParseInto and
+// EndParagraph.
+func (r *Renderer) AddNode(n *Node) *Node {
+	r.cur.AppendChild(n)
+	if r.src != nil && isblock(n.Type) && n.Pos.StartLine == 0 {
+		n.Pos.StartLine, n.Pos.StartCol = r.src.position(r.src.skipnl(r.src.pos))
+		r.src.added = append(r.src.added, n)
+	}
+	return n
+}
+
+// AddText appends text, merging it into a preceding text node.
+func (r *Renderer) AddText(text []byte) {
+	if last := r.cur.LastChild(); last != nil && last.Type == Text {
+		last.Literal = append(last.Literal, text...)
+		return
 	}
+	n := NewNode(Text)
+	n.Literal = append([]byte(nil), text...)
+	r.AddNode(n)
 }
 
-func docomment(text []byte, newblock bool) int {
+// ParseInto parses text into the children of n. If newblock is set text
+// is parsed as blocks, otherwise as inline markup.
+func (r *Renderer) ParseInto(n *Node, text []byte, newblock bool) {
+	saved, src := r.cur, r.src
+	r.cur = n
+	if src != nil {
+		r.src, r.copy = r.submap(text), nil
+		if isblock(n.Type) && n.Pos.StartLine == 0 && len(text) > 0 {
+			n.Pos = r.src.span(0, len(text))
+		}
+	}
+	r.process(text, newblock)
+	r.cur, r.src = saved, src
+}
+
+// EndParagraph closes the open paragraph. Parsers that add a block while
+// inside a paragraph must call it first.
+func (r *Renderer) EndParagraph() {
+	if r.para != nil {
+		if r.cur == r.para {
+			r.cur = r.para.Parent
+		}
+		r.para = nil
+	}
+}
+
+func (r *Renderer) docomment(text []byte, newblock bool) int {
 	begin, end := 0, len(text)
-	if NoHTML || !bytes.HasPrefix(text[begin:], []byte(htmlComment)) {
+	if r.opts.NoHTML || !hasprefix(text[begin:], htmlComment) {
 		return 0
 	}
-	p := bytes.Index(text[begin:], []byte("-->"))
+	p := r.index(text[begin:], "-->")
 
 	if p == -1 || p+3 > end {
 		return 0
 	}
-	fmt.Fprintf(&outbuffer, "%s\n", text[begin:][:p+3])
-	return (p + 3) * map[bool]int{true: -1, false: 1}[newblock]
+	n := r.AddNode(NewNode(Comment))
+	n.Literal = append([]byte(nil), text[begin:][:p+3]...)
+	if newblock {
+		return -(p + 3)
+	}
+	return p + 3
 }
 
-func docodefence(text []byte, newblock bool) int {
+func (r *Renderer) docodefence(text []byte, newblock bool) int {
 	begin, end := 0, len(text)
-	l := len(codeFence)
 
 	if !newblock {
 		return 0
 	}
 
-	if !bytes.HasPrefix(text[begin:], []byte(codeFence)) {
+	/* Up to three spaces and a run of at least three backticks or tildes */
+	indent := begin
+	for indent-begin < 3 && indent < end && text[indent] == ' ' {
+		indent++
+	}
+	p := indent
+	if p == end || (text[p] != '`' && text[p] != '~') {
+		return 0
+	}
+	fence := text[p]
+	for p < end && text[p] == fence {
+		p++
+	}
+	l := p - indent
+	if l < len(codeFence) {
 		return 0
 	}
 
-	/* Find start of content and read language string */
-	start := begin + l
-	langStart := start
-	for start < end && text[start] != '\n' {
-		start++
+	/* Read info string, backtick fences must not contain backticks */
+	infoStop := p
+	for infoStop < end && text[infoStop] != '\n' {
+		infoStop++
+	}
+	info := bytes.TrimSpace(text[p:infoStop])
+	if fence == '`' && bytes.IndexByte(info, '`') != -1 {
+		return 0
 	}
-	langStop := start
-	start++
+	start := min(infoStop+1, end)
 
-	/* Find end of fence */
-	p := start - 1
-	var stop int
-	for {
-		stop = p
-		idx := bytes.Index(text[p+1:], []byte(codeFence))
-		if idx == -1 {
-			p = end
-			break
+	/* Find closing fence. No closing code fence means the rest of file is
+	 * code (CommonMark) */
+	stop, next := end, end
+	for q := start; q < end; {
+		eol := q
+		for eol < end && text[eol] != '\n' {
+			eol++
 		}
-		p += 1 + idx
-		if p >= len(text) || (p > 0 && text[p-1] != '\\') {
-			stop = p
+		if isclosingfence(text[q:eol], fence, l) {
+			stop, next = q, eol
 			break
 		}
+		q = eol + 1
 	}
 
-	/* No closing code fence means the rest of file is code (CommonMark) */
-	if p >= len(text) {
-		stop = end
+	n := r.AddNode(NewNode(CodeBlock))
+	n.Fenced = true
+	n.Info, n.Attrs = codeinfo(info)
+	n.Literal = unindent(text[start:stop], indent-begin)
+	return -(next - begin)
+}
+
+/* isclosingfence reports whether line closes a fence of l fence chars */
+func isclosingfence(line []byte, fence byte, l int) bool {
+	p := 0
+	for p < 3 && p < len(line) && line[p] == ' ' {
+		p++
+	}
+	n := 0
+	for p < len(line) && line[p] == fence {
+		p++
+		n++
+	}
+	for p < len(line) && isSpace(line[p]) {
+		p++
 	}
+	return n >= l && p == len(line)
+}
 
-	/* Print output */
-	if langStart == langStop {
-		fmt.Fprint(&outbuffer, "<pre><code>")
-	} else {
-		fmt.Fprint(&outbuffer, "<pre><code class=\"language-")
-		hprint(text[langStart:langStop])
-		fmt.Fprintln(&outbuffer, "\">")
+/* openfence returns the fence if line opens fenced code */
+func openfence(line []byte) []byte {
+	p := 0
+	for p < 3 && p < len(line) && line[p] == ' ' {
+		p++
 	}
-	hprint(text[start:stop])
-	fmt.Fprint(&outbuffer, "</code></pre>\n")
-	return -(stop - begin + l)
+	start := p
+	for p < len(line) && (line[p] == '`' || line[p] == '~') && line[p] == line[start] {
+		p++
+	}
+	if p-start < len(codeFence) {
+		return nil
+	}
+	return line[start:p]
 }
 
-func dohtml(text []byte, newblock bool) int {
+/* lineend returns the position after the line starting at p */
+func lineend(text []byte, p int) int {
+	if i := bytes.IndexByte(text[p:], '\n'); i != -1 {
+		return p + i + 1
+	}
+	return len(text)
+}
+
+/* unindent removes up to indent spaces from the start of every line */
+func unindent(text []byte, indent int) []byte {
+	var out []byte
+	for len(text) > 0 {
+		for i := 0; i < indent && len(text) > 0 && text[0] == ' '; i++ {
+			text = text[1:]
+		}
+		eol := bytes.IndexByte(text, '\n') + 1
+		if eol == 0 {
+			eol = len(text)
+		}
+		out = append(out, text[:eol]...)
+		text = text[eol:]
+	}
+	return out
+}
+
+/* codeinfo splits the info string of a code fence into the language and
+ * the attributes in braces, as in "go {.class #id linenos=true}" */
+func codeinfo(info []byte) (string, []Attr) {
+	open := bytes.IndexByte(info, '{')
+	if open == -1 || info[len(info)-1] != '}' {
+		return string(info), nil
+	}
+	lang := string(bytes.TrimSpace(info[:open]))
+	body := info[open+1 : len(info)-1]
+
+	var attrs []Attr
+	var classes []string
+	for p := 0; p < len(body); {
+		for p < len(body) && isSpace(body[p]) {
+			p++
+		}
+		start := p
+		for quoted := false; p < len(body) && (quoted || !isSpace(body[p])); p++ {
+			if body[p] == '"' {
+				quoted = !quoted
+			}
+		}
+		token := string(body[start:p])
+		switch {
+		case token == "":
+		case token[0] == '.':
+			classes = append(classes, token[1:])
+		case token[0] == '#':
+			attrs = append(attrs, Attr{"id", token[1:]})
+		default:
+			key, value, _ := strings.Cut(token, "=")
+			if key != "" && strings.IndexFunc(key, func(c rune) bool {
+				return c > unicode.MaxASCII || !isAlnum(byte(c)) && c != '-' && c != '_'
+			}) == -1 {
+				attrs = append(attrs, Attr{key, strings.Trim(value, "\"")})
+			}
+		}
+	}
+	if len(classes) > 0 {
+		attrs = append([]Attr{{"class", strings.Join(classes, " ")}}, attrs...)
+	}
+	return lang, attrs
+}
+
+func (r *Renderer) dohtml(text []byte, newblock bool) int {
 	begin, end := 0, len(text)
 
-	if NoHTML || begin+2 >= end {
+	if r.opts.NoHTML || begin+2 >= end {
 		return 0
 	}
 	p := begin
@@ -238,23 +581,28 @@ func dohtml(text []byte, newblock bool) int {
 		return 0
 	}
 	tag := string(text[tagStart:tagend])
-	closeTag := []byte("</" + tag + ">")
-	closeIdx := bytes.Index(text[p:], closeTag)
+	closeTag := len("</" + tag + ">")
+	closeIdx := r.closetag(text[p:], tag)
 	if closeIdx != -1 {
-		fmt.Fprintf(&outbuffer, "%s", text[begin:p+closeIdx+len(closeTag)])
-		return p + closeIdx + len(closeTag)
+		r.rawhtml(text[begin : p+closeIdx+closeTag])
+		return p + closeIdx + closeTag
 	}
 
-	closeIdx = bytes.IndexByte(text[tagend:], '>')
+	closeIdx = r.index(text[tagend:], ">")
 	if closeIdx != -1 {
-		fmt.Fprintf(&outbuffer, "%s", text[begin:tagend+closeIdx+1])
+		r.rawhtml(text[begin : tagend+closeIdx+1])
 		return tagend + closeIdx + 1
 	}
 
 	return 0
 }
 
-func dolineprefix(text []byte, newBlock bool) int {
+func (r *Renderer) rawhtml(text []byte) {
+	n := r.AddNode(NewNode(RawHTML))
+	n.Literal = append([]byte(nil), text...)
+}
+
+func (r *Renderer) dolineprefix(text []byte, newBlock bool) int {
 	begin, end := 0, len(text)
 
 	var p, consumedInput int
@@ -267,91 +615,108 @@ func dolineprefix(text []byte, newBlock bool) int {
 		return 0
 	}
 
-	for _, lineprefix := range lineprefixs {
+	for _, lineprefix := range r.lineprefixs {
 		l := len(lineprefix.search)
 		if end-p+1 < l {
 			continue
 		}
-		if !bytes.HasPrefix(text[p:], []byte(lineprefix.search)) {
+		if !hasprefix(text[p:], lineprefix.search) {
 			continue
 		}
 
 		if text[begin] == '\n' {
-			fmt.Fprint(&outbuffer, "\n")
+			r.AddText(text[begin : begin+1])
 		}
 
 		/* All line prefixes add a block element. These are not allowed
 		 * inside paragraphs, so we must end the paragraph first. */
-		endParagraph()
+		r.EndParagraph()
 
-		fmt.Fprint(&outbuffer, lineprefix.before)
+		n := r.AddNode(lineprefix.newnode())
 		if lineprefix.search[l-1] == '\n' {
-			fmt.Fprint(&outbuffer, "\n")
 			return l - 1 + consumedInput
 		}
 
 		/* Collect lines into buffer while they start with the prefix */
-		var buffer bytes.Buffer
+		var buffer srccopy
 		var j int
-		for bytes.HasPrefix(text[p:], []byte(lineprefix.search)) && p+l < end {
+		for hasprefix(text[p:], lineprefix.search) && p+l < end {
 			p += l
 
 			/* Special case for blockquotes: optional space after > */
-			if lineprefix.search[0] == '>' && text[p] == ' ' {
+			if lineprefix.search[0] == '>' && p < end && text[p] == ' ' {
 				p++
 			}
 
 			newline := bytes.IndexByte(text[p:], '\n')
 			if newline == -1 {
-				n, _ := buffer.Write(text[p:])
-				j += n
-				p += n
+				buffer.add(text[p:])
+				j += end - p
+				p = end
 			} else {
 				j += newline + 1
-				buffer.Write(text[p : p+newline+1])
+				buffer.add(text[p : p+newline+1])
 				p += newline + 1
 			}
 		}
 
 		/* Skip empty lines in block */
-		bs := buffer.Bytes()
+		bs := buffer.buf
 		for j > 0 && j < len(bs) && bs[j] == '\n' {
 			j--
 		}
 
-		bs = bs[:j]
+		buffer.buf = bs[:j]
+		if n.Type == Blockquote {
+			buffer = buffer.cut(j - len(r.callout(n, buffer.buf)))
+		}
 		if lineprefix.process > 0 {
-			process(bs, lineprefix.process >= 2)
+			r.parsecopy(n, buffer, lineprefix.process >= 2)
 		} else {
-			hprint(bs)
+			n.Literal = append([]byte(nil), buffer.buf...)
 		}
-		fmt.Fprintln(&outbuffer, lineprefix.after)
 		return -(p - begin)
 	}
 	return 0
 }
 
-func dolink(text []byte, newBlock bool) int {
+func (r *Renderer) dolink(text []byte, newBlock bool) int {
 	begin, end := 0, len(text)
-	parensDepth := 1
 
 	var img bool
 	if text[begin] == '[' {
 		img = false
-	} else if bytes.HasPrefix(text[begin:], []byte("![")) {
+	} else if hasprefix(text[begin:], "![") {
 		img = true
 	} else {
 		return 0
 	}
 
 	desc := 1
-	p := desc
 	if img {
 		desc = 2
-		p = desc
 	}
 
-	if idx := bytes.Index(text[desc:], []byte("](")); idx == -1 || p+idx > end {
+	/* Inline links come before references to the same label */
+	if e := r.closing(text, desc-1, '[', ']', true); e != -1 && e+1 < end && text[e+1] == '(' {
+		if l := r.inlinelink(text, desc, img); l != 0 {
+			return l
+		}
+	}
+	if l := r.reflink(text, desc, img); l != 0 {
+		return l
+	}
+	return r.inlinelink(text, desc, img)
+}
+
+/* inlinelink handles the links [text](dest "title") and the images
+ * ![alt](src "title"), whose description starts at desc. It returns the
+ * number of bytes consumed or 0 if text is not a link. */
+func (r *Renderer) inlinelink(text []byte, desc int, img bool) int {
+	begin, end := 0, len(text)
+
+	p := desc
+	if idx := r.index(text[desc:], "]("); idx == -1 || p+idx > end {
 		return 0
 	} else {
 		p += idx
@@ -361,21 +726,9 @@ func dolink(text []byte, newBlock bool) int {
 	link := p + 2
 
 	/* find end of link while handling nested parens */
-	q := link
-	for parensDepth != 0 {
-		idx := bytes.IndexAny(text[q:], "()")
-		if idx == -1 {
-			return 0
-		}
-		q += idx
-		if text[q] == '(' {
-			parensDepth++
-		} else {
-			parensDepth--
-		}
-		if parensDepth != 0 && q < end {
-			q++
-		}
+	q := r.closing(text, link-1, '(', ')', false)
+	if q == -1 {
+		return 0
 	}
 
 	var linkend int
@@ -402,41 +755,39 @@ func dolink(text []byte, newBlock bool) int {
 	}
 
 	/* Links can be given in angular brackets */
-	if text[link] == '<' && text[linkend-1] == '>' {
+	if linkend-link >= 2 && text[link] == '<' && text[linkend-1] == '>' {
 		link++
 		linkend--
 	}
 
-	l := q + 1 - begin
+	var t string
+	if title != -1 && titleend != -1 {
+		t = string(text[title:titleend])
+	}
+	r.addlink(text[desc:descend], string(text[link:linkend]), t, img)
+	return q + 1 - begin
+}
+
+/* addlink adds a link or image with the description desc */
+func (r *Renderer) addlink(desc []byte, dest, title string, img bool) {
+	n := NewNode(Link)
 	if img {
-		fmt.Fprint(&outbuffer, "<img src=\"")
-		hprint(text[link:linkend])
-		fmt.Fprint(&outbuffer, "\" alt=\"")
-		hprint(text[desc:descend])
-		fmt.Fprint(&outbuffer, "\" ")
-		if title != -1 && titleend != -1 {
-			fmt.Fprint(&outbuffer, "title=\"")
-			hprint(text[title:titleend])
-			fmt.Fprint(&outbuffer, "\" ")
-		}
-		fmt.Fprint(&outbuffer, "/>")
+		n.Type = Image
+	}
+	n.Dest = dest
+	n.Title = title
+	r.AddNode(n)
+	if img {
+		/* The description of an image is its plain alt text */
+		alt := NewNode(Text)
+		alt.Literal = append([]byte(nil), desc...)
+		n.AppendChild(alt)
 	} else {
-		fmt.Fprint(&outbuffer, "<a href=\"")
-		hprint(text[link:linkend])
-		fmt.Fprint(&outbuffer, "\"")
-		if title != -1 && titleend != -1 {
-			fmt.Fprint(&outbuffer, " title=\"")
-			hprint(text[title:titleend])
-			fmt.Fprint(&outbuffer, "\"")
-		}
-		fmt.Fprint(&outbuffer, ">")
-		process(text[desc:descend], false)
-		fmt.Fprint(&outbuffer, "</a>")
+		r.ParseInto(n, desc, false)
 	}
-	return l
 }
 
-func dolist(text []byte, newBlock bool) int {
+func (r *Renderer) dolist(text []byte, newBlock bool) int {
 	begin, end := 0, len(text)
 
 	var p int
@@ -447,6 +798,9 @@ func dolist(text []byte, newBlock bool) int {
 	} else {
 		return 0
 	}
+	if p >= end {
+		return 0
+	}
 
 	q := p
 	var marker byte
@@ -468,29 +822,28 @@ func dolist(text []byte, newBlock bool) int {
 		return 0
 	}
 
-	endParagraph()
+	r.EndParagraph()
 	p++
 	for p != end && isSpace(text[p]) {
 		p++
 	}
 	ident := p - q
 	if !newBlock {
-		fmt.Fprint(&outbuffer, "\n")
+		r.AddText(text[begin : begin+1])
 	}
 
-	if marker != 0 {
-		fmt.Fprint(&outbuffer, "<ul>\n")
-	} else if startNumber == 1 {
-		fmt.Fprint(&outbuffer, "<ol>\n")
-	} else {
-		fmt.Fprintf(&outbuffer, "<ol start=\"%d\">\n", startNumber)
-	}
+	list := r.AddNode(NewNode(List))
+	list.Ordered = marker == 0
+	list.Start = startNumber
 
-	var buffer bytes.Buffer
+	var buffer srccopy
 	isBlock := 0
 	var j int
 	for run := true; p < end && run; p++ {
-		buffer.Reset()
+		/* Every item gets a buffer of its own, as the scan caches assume
+		 * that parsed text is never overwritten */
+		buffer = srccopy{}
+		start := p
 		for i := 0; p < end && run; p, i = p+1, i+1 {
 			if text[p] == '\n' {
 				if p+1 == end {
@@ -500,7 +853,7 @@ func dolist(text []byte, newBlock bool) int {
 					for q = p + 1; q < end && isSpace(text[q]); q++ {
 					}
 					if q < end && text[q] == '\n' {
-						buffer.WriteByte('\n')
+						buffer.buf = append(buffer.buf, '\n')
 						i++
 						run = false
 						isBlock++
@@ -530,7 +883,7 @@ func dolist(text []byte, newBlock bool) int {
 					}
 				}
 				if j == ident {
-					buffer.WriteByte('\n')
+					buffer.buf = append(buffer.buf, '\n')
 					i++
 					p += ident
 					run = true
@@ -543,17 +896,26 @@ func dolist(text []byte, newBlock bool) int {
 					run = false
 				}
 			}
-			buffer.WriteByte(text[p])
+			buffer.addbyte(text, p)
+		}
+		item := NewNode(ListItem)
+		list.AppendChild(item)
+		blocks := isBlock > 1 || (isBlock == 1 && run)
+		if checked, ok := taskmarker(buffer.buf); ok {
+			item.Task = true
+			item.Checked = checked
+			/* Paragraphs start after the space behind the checkbox */
+			i := 3
+			for blocks && i < len(buffer.buf) && isSpace(buffer.buf[i]) {
+				i++
+			}
+			buffer = buffer.cut(i)
+		}
+		r.parsecopy(item, buffer, blocks)
+		if r.src != nil && item.Pos.StartLine != 0 {
+			/* Items start at their marker */
+			item.Pos.StartLine, item.Pos.StartCol = r.src.position(r.src.pos + start - ident)
 		}
-		fmt.Fprint(&outbuffer, "<li>")
-		bs := buffer.Bytes()
-		process(bs, isBlock > 1 || (isBlock == 1 && run))
-		fmt.Fprint(&outbuffer, "</li>\n")
-	}
-	if marker != 0 {
-		fmt.Fprint(&outbuffer, "</ul>\n")
-	} else {
-		fmt.Fprint(&outbuffer, "</ol>\n")
 	}
 	p--
 	p--
@@ -563,215 +925,471 @@ func dolist(text []byte, newBlock bool) int {
 	return -(p - begin + 1)
 }
 
-var intable, inrow, incell int
-var calign int64
+/* taskmarker reports whether a list item starts with "[ ]" or "[x]" */
+func taskmarker(item []byte) (checked, ok bool) {
+	if len(item) < 4 || item[0] != '[' || item[2] != ']' || !isSpace(item[3]) {
+		return false, false
+	}
+	switch item[1] {
+	case ' ':
+		return false, true
+	case 'x', 'X':
+		return true, true
+	}
+	return false, false
+}
 
-func dotable(text []byte, newBlock bool) int {
+func (r *Renderer) dodeflist(text []byte, newBlock bool) int {
 	begin, end := 0, len(text)
 
-	l := 8 * 4 // sizeof(calign) * 4
-
-	var p int
-	if text[begin] != '|' {
+	if !newBlock {
 		return 0
 	}
-	if intable == 2 { /* in alignment row, skip it. */
-		intable++
-		p = begin
-		for p < end && text[p] != '\n' {
-			p++
+
+	var dl *Node
+	p := begin
+	for p < end {
+		/* One or more terms, each on a line of its own */
+		q := p
+		for q < end && !isblankline(text[q:]) && defmarker(text[q:]) == 0 {
+			q = lineend(text, q)
 		}
-		return p - begin + 1
-	}
+		terms := q
+		for q < end && isblankline(text[q:]) {
+			q = lineend(text, q)
+		}
+		if terms == p || q == end || defmarker(text[q:]) == 0 {
+			break
+		}
+		loose := q > terms
 
-	if inrow != 0 && (begin+1 >= end || text[begin+1] == '\n') { /* close cell and row and if ends, table too */
-		if inrow == -1 {
-			fmt.Fprintf(&outbuffer, "</t%c></tr>", 'h')
-		} else {
-			fmt.Fprintf(&outbuffer, "</t%c></tr>", 'd')
+		if dl == nil {
+			r.EndParagraph()
+			dl = r.AddNode(NewNode(DefinitionList))
 		}
-		if inrow == -1 {
-			intable = 2
+		for t := p; t < terms; t = lineend(text, t) {
+			dt := NewNode(DefinitionTerm)
+			dl.AppendChild(dt)
+			r.ParseInto(dt, bytes.TrimSpace(text[t:lineend(text, t)]), false)
 		}
-		inrow = 0
-		if end-begin <= 2 || text[begin+2] == '\n' {
-			intable = 0
-			fmt.Fprint(&outbuffer, "\n</table>\n")
+
+		/* Definitions, separated by blank lines if they are loose */
+		for p = q; ; {
+			body, next := defbody(text[p:])
+			p += next
+			dd := NewNode(Definition)
+			dl.AppendChild(dd)
+			blocks := loose || bytes.Contains(body.buf, []byte("\n\n"))
+			for l := 0; l < len(body.buf) && !blocks; l = lineend(body.buf, l) {
+				blocks = openfence(body.buf[l:]) != nil
+			}
+			r.parsecopy(dd, body, blocks)
+
+			for q = p; q < end && isblankline(text[q:]); q = lineend(text, q) {
+			}
+			if q == end || defmarker(text[q:]) == 0 {
+				break
+			}
+			loose = q > p
+			p = q
 		}
-		return 1
+
+		/* Blank lines may separate the terms */
+		for q = p; q < end && isblankline(text[q:]); q = lineend(text, q) {
+		}
+		p = q
 	}
+	if dl == nil {
+		return 0
+	}
+	return -(p - begin)
+}
 
-	if intable == 0 { /* open table */
-		intable = 1
-		inrow = -1
-		incell = 0
-		calign = 0
-		p = begin
-		for p < end && text[p] != '\n' {
-			p++
+/* defmarker returns the start of the definition if text starts with ": "
+ * after up to three spaces, or 0 */
+func defmarker(text []byte) int {
+	p := 0
+	for p < 3 && p < len(text) && text[p] == ' ' {
+		p++
+	}
+	if p+1 >= len(text) || text[p] != ':' || !isSpace(text[p+1]) {
+		return 0
+	}
+	for p++; p < len(text) && isSpace(text[p]); p++ {
+	}
+	return p
+}
+
+/* defbody returns the unindented content of the definition at the start of
+ * text and its end. It ends before a term or a definition, or at a blank
+ * line unless an indented line follows. */
+func defbody(text []byte) (srccopy, int) {
+	var body srccopy
+	start := defmarker(text)
+	eol := lineend(text, 0)
+	body.add(text[start:eol])
+	p := eol
+	for p < len(text) {
+		q := p
+		for q < len(text) && isblankline(text[q:]) {
+			q = lineend(text, q)
 		}
-		if p < end && text[p] == '\n' { /* load alignment from 2nd line */
-			for i, p := -1, p+1; p < end && text[p] != '\n'; p++ {
-				if text[p] == '|' {
-					i++
-					for p+1 < end && isSpace(text[p+1]) {
-						p++
-					}
-					if i < l && p+1 < end && text[p+1] == ':' {
-						calign |= 1 << (i * 2)
-					}
-					if p+1 < end && text[p+1] == '\n' {
-						break
-					}
-				} else if i < l && text[p] == ':' {
-					calign |= 1 << (i*2 + 1)
-				}
+		if q == len(text) || defmarker(text[q:]) != 0 {
+			break
+		}
+		line := text[q:lineend(text, q)]
+		i := 0
+		for i < 4 && i < len(line) && line[i] == ' ' {
+			i++
+		}
+		if i < len(line) && line[i] == '\t' {
+			i++
+		}
+		switch {
+		case i >= 2 || (i > 0 && line[i-1] == '\t'):
+			body.add(text[p:q])
+			body.add(line[i:])
+		case q > p:
+			/* unindented lines after a blank line end the definition */
+			return body, p
+		default:
+			/* A lazy continuation line unless it starts the next term */
+			next := lineend(text, q)
+			if next < len(text) && defmarker(text[next:]) != 0 {
+				return body, p
 			}
-			fmt.Fprint(&outbuffer, "<table>\n<tr>")
+			body.add(line)
+		}
+		p = q + len(line)
+	}
+	return body, p
+}
+
+/* isblankline reports whether the line starting text has only white space */
+func isblankline(text []byte) bool {
+	return len(bytes.TrimSpace(text[:lineend(text, 0)])) == 0
+}
+
+func (r *Renderer) dotable(text []byte, newBlock bool) int {
+	begin, end := 0, len(text)
+
+	if !newBlock {
+		return 0
+	}
+	header, aligns, p := tablehead(text[begin:])
+	if p == 0 {
+		/* Tables of the original smu need no delimiter row, their first
+		 * row is the header */
+		if text[begin] != '|' {
+			return 0
 		}
+		p = lineend(text, begin)
+		if tabledelims(text[p:lineend(text, p)]) != nil {
+			return 0
+		}
+		header, _ = tablecells(text[begin:p])
+		aligns = make([]Align, len(header))
 	}
 
-	/* open row */
-	if inrow == 0 {
-		inrow = 1
-		incell = 0
-		fmt.Fprint(&outbuffer, "<tr>")
+	table := r.AddNode(NewNode(Table))
+	r.addrow(table, header, aligns, true)
+	/* Rows continue up to a blank line or a line without a pipe */
+	for p < end {
+		eol := lineend(text, p)
+		cells, pipe := tablecells(text[p:eol])
+		if !pipe {
+			break
+		}
+		r.addrow(table, cells, aligns, false)
+		p = eol
 	}
+	return -(p - begin)
+}
 
-	typ := 'd'
-	if inrow == -1 {
-		typ = 'h'
+/* tablehead reads the header and delimiter rows of a table. It returns the
+ * header cells, the column alignments and the end of the delimiter row,
+ * which is 0 if text does not start with a table. */
+func tablehead(text []byte) ([][]byte, []Align, int) {
+	eol := lineend(text, 0)
+	if eol == len(text) {
+		return nil, nil, 0
 	}
+	header, pipe := tablecells(text[:eol])
+	if !pipe {
+		return nil, nil, 0
+	}
+	next := lineend(text, eol)
+	aligns := tabledelims(text[eol:next])
+	if aligns == nil || len(aligns) != len(header) {
+		return nil, nil, 0
+	}
+	return header, aligns, next
+}
 
-	/* close cell */
-	if incell != 0 {
-		fmt.Fprintf(&outbuffer, "</t%c>", typ)
+/* tabledelims returns the column alignments of a delimiter row, or nil if
+ * line is none */
+func tabledelims(line []byte) []Align {
+	delims, pipe := tablecells(line)
+	if !pipe {
+		return nil
+	}
+	aligns := make([]Align, len(delims))
+	for i, d := range delims {
+		d = bytes.TrimSpace(d)
+		left := len(d) > 0 && d[0] == ':'
+		right := len(d) > 1 && d[len(d)-1] == ':'
+		if left {
+			d = d[1:]
+		}
+		if right {
+			d = d[:len(d)-1]
+		}
+		if len(d) == 0 || len(bytes.Trim(d, "-")) != 0 {
+			return nil
+		}
+		switch {
+		case left && right:
+			aligns[i] = AlignCenter
+		case left:
+			aligns[i] = AlignLeft
+		case right:
+			aligns[i] = AlignRight
+		}
 	}
+	return aligns
+}
 
-	/* open cell */
-	align := 0
-	if incell < l {
-		align = int((calign >> (incell * 2)) & 3)
+/* tablecells splits a table row into its cells and reports whether it
+ * contains a pipe. Leading and trailing pipes are optional, escaped pipes
+ * and pipes in code spans do not separate cells. */
+func tablecells(line []byte) ([][]byte, bool) {
+	line = bytes.TrimSpace(line)
+	pipe := false
+	if len(line) > 0 && line[0] == '|' {
+		line = line[1:]
+		pipe = true
+	}
+	var cells [][]byte
+	start := 0
+	for p := 0; p < len(line); p++ {
+		switch line[p] {
+		case '\\':
+			p++
+		case '`':
+			run := p
+			for p < len(line) && line[p] == '`' {
+				p++
+			}
+			fence := line[run:p]
+			if i := bytes.Index(line[p:], fence); i != -1 {
+				p += i + len(fence)
+			}
+			p--
+		case '|':
+			cells = append(cells, line[start:p])
+			start = p + 1
+			pipe = true
+		}
+	}
+	if start < len(line) || len(cells) == 0 {
+		cells = append(cells, line[start:])
 	}
+	return cells, pipe
+}
 
-	fmt.Fprintf(&outbuffer, "<t%c%s>", typ, alignTable[align])
-	incell++
-	for p = begin + 1; p < end && isSpace(text[p]); p++ {
+/* addrow adds a row with a cell for every column to table */
+func (r *Renderer) addrow(table *Node, cells [][]byte, aligns []Align, header bool) {
+	row := NewNode(TableRow)
+	row.Header = header
+	table.AppendChild(row)
+	for i, align := range aligns {
+		cell := NewNode(TableCell)
+		cell.Header = header
+		cell.Align = align
+		row.AppendChild(cell)
+		if i < len(cells) {
+			content := bytes.TrimSpace(cells[i])
+			if !bytes.Contains(content, []byte("\\|")) {
+				r.ParseInto(cell, content, false)
+				continue
+			}
+			/* Escaped pipes are copied without their backslash */
+			var c srccopy
+			for j := bytes.Index(content, []byte("\\|")); j != -1; j = bytes.Index(content, []byte("\\|")) {
+				c.add(content[:j])
+				content = content[j+1:]
+			}
+			c.add(content)
+			r.parsecopy(cell, c, false)
+		}
+	}
+}
+
+/* paragraphend returns the position of the blank line or code fence that
+ * ends a paragraph continuing with text, or -1 */
+func paragraphend(text []byte) int {
+	for p := 0; p < len(text); p++ {
+		i := bytes.IndexByte(text[p:], '\n')
+		if i == -1 {
+			break
+		}
+		p += i
+		if p+1 < len(text) && text[p+1] == '\n' || isfence(text[p+1:]) {
+			return p
+		}
+	}
+	return -1
+}
+
+/* isfence reports whether text starts with up to three spaces and the
+ * start of a code fence */
+func isfence(text []byte) bool {
+	p := 0
+	for p < 3 && p < len(text) && text[p] == ' ' {
+		p++
 	}
-	return p - begin
+	return hasprefix(text[p:], codeFence) || hasprefix(text[p:], "~~~")
 }
 
-func doparagraph(text []byte, newBlock bool) int {
+func (r *Renderer) doparagraph(text []byte, newBlock bool) int {
 	begin, end := 0, len(text)
 
 	if !newBlock {
 		return 0
 	}
 
-	var p int
-	if match := pEndRegex.FindIndex(text[begin+1:]); match == nil {
-		p = end
-	} else {
-		p = begin + 1 + match[0]
+	p := end
+	if i := paragraphend(text[begin+1:]); i != -1 {
+		p = begin + 1 + i
+	}
+
+	/* A table may follow the last line of a paragraph */
+	for q := lineend(text, begin); q < p; q = lineend(text, q) {
+		if _, _, next := tablehead(text[q:p]); next != 0 {
+			p = q
+			break
+		}
 	}
 
-	fmt.Fprint(&outbuffer, "<p>")
-	inParagraph = true
-	process(text[begin:p], false)
-	endParagraph()
+	r.para = r.AddNode(NewNode(Paragraph))
+	r.ParseInto(r.para, text[begin:p], false)
+	r.EndParagraph()
 
 	return -(p - begin)
 }
 
-func doreplace(text []byte, newBlock bool) int {
+func (r *Renderer) doreplace(text []byte, newBlock bool) int {
 	begin, end := 0, len(text)
 
-	for _, replace := range replaces {
+	for _, i := range replaceIdx[text[begin]] {
+		replace := replaces[i]
 		l := len(replace[0])
-		if end-begin < l {
-			continue
-		}
-		if bytes.HasPrefix(text[begin:begin+l], []byte(replace[0])) {
-			fmt.Fprint(&outbuffer, replace[1])
+		if hasprefix(text[begin:end], replace[0]) {
+			switch {
+			case replace[0] == hardBreak:
+				r.AddNode(NewNode(LineBreak))
+			case len(replace[1]) > 1 && replace[1][0] == '&':
+				/* Character references are written as they are */
+				r.rawhtml([]byte(replace[1]))
+			default:
+				r.AddText([]byte(replace[1]))
+			}
 			return l
 		}
 	}
 	return 0
 }
 
-func doshortlink(text []byte, newBlock bool) int {
-	begin, end := 0, len(text)
+func (r *Renderer) doshortlink(text []byte, newBlock bool) int {
+	begin := 0
 	var ismall int
 
 	if text[begin] != '<' {
 		return 0
 	}
-	for p := begin + 1; p != end; p++ {
-		switch text[p] {
-		case ' ', '\t', '\n':
-			return 0
-		case '#', ':':
-			ismall = -1
-		case '@':
-			if ismall == 0 {
-				ismall = 1
-			}
-		case '>':
-			if ismall == 0 {
-				return 0
-			}
-			fmt.Fprint(&outbuffer, "<a href=\"")
-			if ismall == 1 {
-				fmt.Fprint(&outbuffer, "&#x6D;&#x61;i&#x6C;&#x74;&#x6F;:")
-				for c := begin + 1; c < p; c++ {
-					fmt.Fprintf(&outbuffer, "&#%d;", text[c])
-				}
-				fmt.Fprint(&outbuffer, "\">")
-				for c := begin + 1; c < p; c++ {
-					fmt.Fprintf(&outbuffer, "&#%d;", text[c])
-				}
-			} else {
-				hprint(text[begin+1 : p])
-				fmt.Fprint(&outbuffer, "\">")
-				hprint(text[begin+1 : p])
-			}
-			fmt.Fprint(&outbuffer, "</a>")
-			return p - begin + 1
-		}
+
+	/* The address ends at the first '>' and has no white space. It is a
+	 * URL if it has a '#' or ':', else a mail address if it has a '@'. */
+	start := begin + 1
+	p := r.indexany(text[start:], " \t\n>")
+	if p == -1 || text[start+p] != '>' {
+		return 0
 	}
-	return 0
+	p += start
+	if i := r.indexany(text[start:], "#:"); i != -1 && start+i < p {
+		ismall = -1
+	} else if i := r.index(text[start:], "@"); i != -1 && start+i < p {
+		ismall = 1
+	}
+	if ismall == 0 {
+		return 0
+	}
+
+	n := r.AddNode(NewNode(Link))
+	n.Autolink = true
+	n.Dest = string(text[start:p])
+	if ismall == 1 {
+		n.Dest = "mailto:" + n.Dest
+	}
+	label := NewNode(Text)
+	label.Literal = append([]byte(nil), text[start:p]...)
+	n.AppendChild(label)
+	return p - begin + 1
 }
 
-func dosurround(text []byte, newBlock bool) int {
+func (r *Renderer) dosurround(text []byte, newBlock bool) int {
 	begin, end := 0, len(text)
-	for _, surround := range surrounds {
+	for _, surround := range r.surrounds {
 		l := len(surround.search)
-		if end-begin < 2*l || !bytes.HasPrefix(text[begin:], []byte(surround.search)) {
+		if end-begin < 2*l || !hasprefix(text[begin:], surround.search) {
 			continue
 		}
 		start := begin + l
 		p := start
-		var stop int
+		stop := -1
+
+		/* Superscripts and subscripts are single words, runs of their
+		 * delimiter are text */
+		short := l == 1 && slices.Contains(extensions, surround)
+		if short && text[start] == surround.search[0] {
+			for p < end && text[p] == surround.search[0] {
+				p++
+			}
+			r.AddText(text[begin:p])
+			return p - begin
+		}
 
 		for p < end {
-			idx := bytes.Index(text[p:], []byte(surround.search))
+			idx := r.index(text[p:], surround.search)
 			if idx == -1 {
 				break
 			}
-			stop = p + idx
-
-			if stop > start && text[stop-1] == '\\' {
-				p = stop + 1
+			q := p + idx
+
+			/* Escaped delimiters and those in runs do not close
+			 * markup, nor do ones right after the opening delimiter.
+			 * Code ends at an escaped delimiter if no other one
+			 * follows, the backslash is literal then. */
+			if q > start && text[q-1] == '\\' && surround.process == 0 {
+				stop = q
+				p = q + 1
 				continue
 			}
+			if surround.process > 0 && (q == start || text[q-1] == '\\') ||
+				short && (text[q-1] == text[q] || q+1 < end && text[q+1] == text[q]) {
+				p = q + 1
+				continue
+			}
+			stop = q
 			break
 		}
 
 		if stop < start || stop >= end {
 			continue
 		}
-
-		fmt.Fprint(&outbuffer, surround.before)
+		if short && bytes.ContainsAny(text[start:stop], " \t\n") {
+			continue
+		}
 
 		/* Single space at start and end are ignored */
 		if start < stop && text[start] == ' ' && text[stop-1] == ' ' && start < stop-1 {
@@ -779,26 +1397,46 @@ func dosurround(text []byte, newBlock bool) int {
 			stop--
 		}
 
+		var n *Node
+		if surround.node == Emphasis {
+			n = r.addemphasis(surround.level)
+		} else {
+			n = r.AddNode(surround.newnode())
+		}
 		if surround.process > 0 {
-			process(text[start:stop], false)
+			r.ParseInto(n, text[start:stop], false)
 		} else {
-			hprint(text[start:stop])
+			n.Literal = append([]byte(nil), text[start:stop]...)
 		}
-		fmt.Fprint(&outbuffer, surround.after)
 		return stop - begin + l
 	}
 	return 0
 }
 
-func dounderline(text []byte, newBlock bool) int {
+/* addemphasis adds the nodes for a run of level emphasis delimiters and
+ * returns the innermost one */
+func (r *Renderer) addemphasis(level int) *Node {
+	n := r.AddNode(NewNode(Emphasis))
+	if level >= 2 {
+		n.Type = Strong
+	}
+	if level >= 3 {
+		inner := NewNode(Emphasis)
+		n.AppendChild(inner)
+		return inner
+	}
+	return n
+}
+
+func (r *Renderer) dounderline(text []byte, newBlock bool) int {
 	begin, end := 0, len(text)
 	if !newBlock {
 		return 0
 	}
 	p := begin
-	l := 0
-	for p+l < end && text[p+l] != '\n' {
-		l++
+	l := r.index(text[begin:], "\n")
+	if l == -1 {
+		l = end - begin
 	}
 	p += l + 1
 	if l == 0 || p >= end {
@@ -812,43 +1450,33 @@ func dounderline(text []byte, newBlock bool) int {
 		}
 
 		if j >= 3 {
-			fmt.Fprint(&outbuffer, underline.before)
+			/* The delimiter row of a table is no underline */
+			if _, _, next := tablehead(text[begin:]); next != 0 {
+				return 0
+			}
+			n := r.AddNode(underline.newnode())
 			if underline.process > 0 {
-				process(text[:l], false)
+				r.ParseInto(n, text[:l], false)
 			} else {
-				hprint(text[:l])
+				n.Literal = append([]byte(nil), text[:l]...)
 			}
-			fmt.Fprint(&outbuffer, underline.after)
 			return -(j + p - begin)
 		}
 	}
 	return 0
 }
 
-func hprint(text []byte) {
-	for len(text) > 0 {
-		r, size := utf8.DecodeRune(text)
-		if r == utf8.RuneError {
-			break
-		}
-
-		switch r {
-		case '&':
-			fmt.Fprint(&outbuffer, "&amp;")
-		case '"':
-			fmt.Fprint(&outbuffer, "&quot;")
-		case '>':
-			fmt.Fprint(&outbuffer, "&gt;")
-		case '<':
-			fmt.Fprint(&outbuffer, "&lt;")
-		default:
-			fmt.Fprintf(&outbuffer, "%c", r)
-		}
-		text = text[size:]
+func (r *Renderer) process(text []byte, newblock bool) {
+	/* Too deeply nested markup and the text left when the budget runs
+	 * out are plain text */
+	ok := r.nest()
+	defer func() { r.lim.depth-- }()
+	if !ok {
+		r.AddText(text)
+		return
 	}
-}
 
-func process(text []byte, newblock bool) {
+	d := r.inlineparsers()
 	begin, end := 0, len(text)
 	for p := begin; p < end; {
 		if newblock {
@@ -859,31 +1487,38 @@ func process(text []byte, newblock bool) {
 				}
 			}
 		}
+		if !r.step() {
+			r.AddText(text[p:end])
+			return
+		}
 
+		if r.src != nil {
+			r.src.pos = p
+		}
 		affected := 0
-		for _, parser := range parsers {
-			affected = parser(text[p:end], newblock)
-			if affected != 0 {
-				break
+		if newblock {
+			for _, parser := range r.parsers {
+				if affected = parser.parse(r, text[p:end], true); affected != 0 {
+					break
+				}
 			}
+		} else {
+			for _, parse := range d[text[p]] {
+				if affected = parse(r, text[p:end], false); affected != 0 {
+					break
+				}
+			}
+		}
+		if r.src != nil {
+			r.endblocks(p + abs(affected))
 		}
 
 		if affected != 0 {
 			p += abs(affected)
 		} else {
-			if text[p] < utf8.RuneSelf {
-				fmt.Fprintf(&outbuffer, "%c", text[p])
-				p++
-			} else {
-				r, size := utf8.DecodeRune(text[p:])
-				if r != utf8.RuneError {
-					fmt.Fprintf(&outbuffer, "%c", r)
-					p += size
-				} else {
-					fmt.Fprintf(&outbuffer, "%c", text[p])
-					p++
-				}
-			}
+			l := d.plaintext(text[p:end])
+			r.AddText(text[p : p+l])
+			p += l
 		}
 
 		/* Don't print single newline at end */
@@ -899,11 +1534,197 @@ func process(text []byte, newblock bool) {
 	}
 }
 
+// New returns a Renderer configured with opts.
+func New(opts Options) *Renderer {
+	r := &Renderer{
+		opts:        opts,
+		parsers:     slices.Clone(parsers),
+		lineprefixs: slices.Clone(lineprefixs),
+		surrounds:   slices.Clone(surrounds),
+	}
+	for i, enabled := range []bool{opts.Strikethrough, opts.Mark, opts.Superscript, opts.Subscript} {
+		if enabled {
+			r.surrounds = insertTag(r.surrounds, extensions[i])
+		}
+	}
+	return r
+}
+
+// AddParser registers p to run at the given priority. It runs before any
+// built-in parser of the same priority and after parsers that were added
+// earlier with that priority. Block parsers usually run before
+// PriorityParagraph, inline parsers before PriorityReplace.
+func (r *Renderer) AddParser(p Parser, priority int) {
+	i := 0
+	for i < len(r.parsers) && (r.parsers[i].priority < priority ||
+		r.parsers[i].priority == priority && !r.parsers[i].builtin) {
+		i++
+	}
+	r.parsers = slices.Insert(r.parsers, i, parserEntry{p, priority, false, nil})
+	r.dispatch = nil
+}
+
+// AddSurround registers t as inline markup between two delimiters.
+func (r *Renderer) AddSurround(t Tag) {
+	t.node = CustomInline
+	r.surrounds = insertTag(r.surrounds, t)
+	r.dispatch = nil
+}
+
+// RemoveSurround removes the inline markup delimited by search.
+func (r *Renderer) RemoveSurround(search string) {
+	r.surrounds = removeTag(r.surrounds, search)
+	r.dispatch = nil
+}
+
+// AddLinePrefix registers t as a block of lines starting with a prefix.
+func (r *Renderer) AddLinePrefix(t Tag) {
+	t.node = CustomBlock
+	r.lineprefixs = insertTag(r.lineprefixs, t)
+}
+
+// RemoveLinePrefix removes the block markup introduced by search.
+func (r *Renderer) RemoveLinePrefix(search string) {
+	r.lineprefixs = removeTag(r.lineprefixs, search)
+}
+
+/* insertTag adds t in front of tags it would otherwise be shadowed by, so
+ * longer delimiters are tried first. A tag with the same search is
+ * replaced. */
+func insertTag(tags []Tag, t Tag) []Tag {
+	tags = removeTag(tags, t.search)
+	i := 0
+	for i < len(tags) && !strings.HasPrefix(t.search, tags[i].search) {
+		i++
+	}
+	return slices.Insert(tags, i, t)
+}
+
+func removeTag(tags []Tag, search string) []Tag {
+	return slices.DeleteFunc(tags, func(t Tag) bool { return t.search == search })
+}
+
+// Parse parses text into a document tree. With Options.HeadingIDs or
+// HeadingAnchors, headings are given unique ids derived from their text.
+// No input makes Parse or Process panic; custom parsers and hooks are
+// outside this guarantee, see TryProcess.
+func (r *Renderer) Parse(text []byte) *Node {
+	r.reset()
+	text = r.startlimits(text)
+	if r.opts.CommonMark {
+		doc := r.parsecommonmark(text)
+		if r.opts.HeadingIDs || r.opts.HeadingAnchors {
+			headingids(doc)
+		}
+		return doc
+	}
+	doc := NewNode(Document)
+	r.cur = doc
+	body := r.definitions(text)
+	if r.opts.SourcePos {
+		r.src, r.srclines = newsrcmap(body), bytes.Split(text, []byte("\n"))
+	}
+	r.process(body, true)
+	r.addfootnotes(doc, text)
+	if r.src != nil {
+		fixsourcepos(doc, r.srclines)
+	}
+	r.reset()
+	if r.opts.HeadingIDs || r.opts.HeadingAnchors {
+		headingids(doc)
+	}
+	return doc
+}
+
+// Process renders text to HTML. The returned slice is owned by the caller.
+func (r *Renderer) Process(text []byte) []byte {
+	var buf bytes.Buffer
+	r.RenderNode(&buf, r.Parse(text))
+	return buf.Bytes()
+}
+
+// TryProcess is like Process, but returns a *PanicError instead of
+// panicking, for example in a server that runs custom parsers. It returns
+// the output and ErrLimit if the input exceeded the limits in Options.
+func (r *Renderer) TryProcess(text []byte) (out []byte, err error) {
+	defer r.catch(&err)
+	out = r.Process(text)
+	if r.lim.exceeded {
+		return out, ErrLimit
+	}
+	return out, nil
+}
+
+// Render reads all of in and writes the HTML to w block by block. It
+// returns the first error from reading in or writing to w, a *PanicError
+// or ErrLimit.
+func (r *Renderer) Render(w io.Writer, in io.Reader) (err error) {
+	defer r.catch(&err)
+	text, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	if err := r.RenderNode(w, r.Parse(text)); err != nil {
+		return err
+	}
+	if r.lim.exceeded {
+		return ErrLimit
+	}
+	return nil
+}
+
+// PanicError is returned by TryProcess and Render if parsing or rendering
+// panicked, which is a bug in smu or in a custom parser, hook or
+// highlighter.
+type PanicError struct {
+	Value any    // value passed to panic
+	Stack []byte // stack of the panicking goroutine
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("smu: panic: %v", e.Value)
+}
+
+/* catch recovers from a panic, stores it in *err and resets the renderer
+ * so it can be used again */
+func (r *Renderer) catch(err *error) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	*err = &PanicError{Value: v, Stack: debug.Stack()}
+	r.reset()
+	r.out.Reset()
+	r.w = nil
+}
+
+func (r *Renderer) reset() {
+	r.cur, r.para = nil, nil
+	r.footnotes, r.fnlist = map[string]*footnote{}, nil
+	r.refs = map[string]linkref{}
+	r.src, r.srclines = nil, nil
+	r.lim = limits{exceeded: r.lim.exceeded}
+}
+
+// Process renders text to HTML with a Renderer using the default options.
 func Process(text []byte) []byte {
-	process(text, true)
-	result := outbuffer.Bytes()
-	outbuffer.Reset()
-	return result
+	return New(Options{}).Process(text)
+}
+
+// TryProcess renders text to HTML with a Renderer using the default
+// options, see Renderer.TryProcess.
+func TryProcess(text []byte) ([]byte, error) {
+	return New(Options{}).TryProcess(text)
+}
+
+// Render renders in to w with a Renderer using the default options.
+func Render(w io.Writer, in io.Reader) error {
+	return New(Options{}).Render(w, in)
+}
+
+// Parse parses text with a Renderer using the default options.
+func Parse(text []byte) *Node {
+	return New(Options{}).Parse(text)
 }
 
 func abs(n int) int {
@@ -914,27 +1735,25 @@ func abs(n int) int {
 }
 
 func isDigit(c byte) bool {
-	r, _ := utf8.DecodeRune([]byte{c})
-	if r == utf8.RuneError {
-		return false
-	}
-	return unicode.IsDigit(r)
+	return '0' <= c && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	c |= 0x20
+	return 'a' <= c && c <= 'z'
 }
 
 func isAlpha(c byte) bool {
-	r, _ := utf8.DecodeRune([]byte{c})
-	if r == utf8.RuneError {
-		return false
-	}
-	return unicode.IsLetter(r) || r == '_'
+	return isLetter(c) || c == '_'
 }
 
 func isAlnum(c byte) bool {
-	r, _ := utf8.DecodeRune([]byte{c})
-	if r == utf8.RuneError {
-		return false
-	}
-	return unicode.IsLetter(r) || unicode.IsDigit(r)
+	return isLetter(c) || isDigit(c)
+}
+
+/* hasprefix is bytes.HasPrefix for a string, which it does not copy */
+func hasprefix(text []byte, prefix string) bool {
+	return len(text) >= len(prefix) && string(text[:len(prefix)]) == prefix
 }
 
 func isSpace(c byte) bool {
@@ -0,0 +1,189 @@
+package smu
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestEscapes checks that escaped characters HTML gives meaning to are
+// written as character references, as the original smu does.
+func TestEscapes(t *testing.T) {
+	tests := []struct{ text, want string }{
+		{`\" \& \< \>`, "<p>&quot; &amp; &lt; &gt;</p>\n"},
+		{`" & < b >`, "<p>\" &amp; &lt; b &gt;</p>\n"},
+		{`*\"a\"* [\"b\"](c)`, "<p><em>&quot;a&quot;</em> <a href=\"c\">&quot;b&quot;</a></p>\n"},
+		{"`\\\"`", "<p><code>\\&quot;</code></p>\n"},
+
+		/* Escaped delimiters end code if no other one does, they never
+		 * end emphasis */
+		{"path `C:\\` here", "<p>path <code>C:\\</code> here</p>\n"},
+		{"``a\\``", "<p><code>a\\</code></p>\n"},
+		{"``\\`a\\```", "<p><code>\\`a\\`</code></p>\n"},
+		{`*a\*`, "<p>*a*</p>\n"},
+		{`**a\**b`, "<p><em>*a*</em>b</p>\n"},
+	}
+	for _, opts := range []Options{{}, {Safe: true}} {
+		for _, tt := range tests {
+			if got := string(New(opts).Process([]byte(tt.text))); got != tt.want {
+				t.Errorf("%+v %q:\ngot  %q\nwant %q", opts, tt.text, got, tt.want)
+			}
+		}
+	}
+
+	/* They are text in the table of contents */
+	toc := TOC(New(Options{}).Parse([]byte(`# say \"hi\" \& \<b\>`)))
+	if want := `say "hi" & <b>`; len(toc) != 1 || toc[0].Text != want {
+		t.Errorf("toc %v, want the text %q", toc, want)
+	}
+}
+
+// TestAddParser checks that custom parsers run in the order of their
+// priority, before the built-in parsers of the same priority and after
+// those added earlier.
+func TestAddParser(t *testing.T) {
+	/* claim returns a parser that turns the byte c into an element */
+	claim := func(element string, c byte) Parser {
+		return func(r *Renderer, text []byte, newblock bool) int {
+			if text[0] != c {
+				return 0
+			}
+			n := r.AddNode(NewNode(CustomInline))
+			n.Element = element
+			return 1
+		}
+	}
+
+	r := New(Options{})
+	r.AddParser(claim("a", '*'), PrioritySurround)
+	r.AddParser(claim("b", '*'), PrioritySurround)
+	r.AddParser(claim("c", '\\'), PriorityReplace+1)
+	r.AddParser(func(r *Renderer, text []byte, newblock bool) int {
+		if !newblock || text[0] != '#' {
+			return 0
+		}
+		r.AddNode(NewNode(CustomBlock)).Element = "d"
+		return -len(text)
+	}, PriorityLinePrefix)
+	r.AddParser(claim("e", '`'), PriorityReplace)
+	tests := []struct{ text, want string }{
+		{"*x*", "<p><a></a>x<a></a></p>\n"},
+		{`\* \q`, "<p>* <c></c>q</p>\n"},
+		{"# h\n\n## i", "<d></d>\n"},
+		{"`x`", "<p><code>x</code></p>\n"},
+		{"x`", "<p>x<e></e></p>\n"},
+	}
+	for _, tt := range tests {
+		if got := string(r.Process([]byte(tt.text))); got != tt.want {
+			t.Errorf("%q:\ngot  %q\nwant %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+// TestCustomTags checks that surrounds and line prefixes can be added and
+// removed, and take effect on a renderer that has already been used.
+func TestCustomTags(t *testing.T) {
+	r := New(Options{})
+	steps := []struct {
+		change     func()
+		text, want string
+	}{
+		{func() {}, "%%*a*%% @@*b*@@ !!*c*!!", "<p>%%<em>a</em>%% @@<em>b</em>@@ !!<em>c</em>!!</p>\n"},
+		{func() { r.AddSurround(NewTag("%%", 0, "kbd", "")) }, "%%*a*%%", "<p><kbd>*a*</kbd></p>\n"},
+		{func() { r.AddSurround(NewTag("@@", 1, "span", "x")) }, "@@*b*@@", "<p><span class=\"x\"><em>b</em></span></p>\n"},
+		{func() { r.AddSurround(NewTag("!!", 2, "span", "")) }, "!!# c!!", "<p><span># c</span></p>\n"},
+		{func() { r.RemoveSurround("**") }, "**d**", "<p><em>*d</em>*</p>\n"},
+		{func() { r.RemoveSurround("%%") }, "%%*a*%%", "<p>%%<em>a</em>%%</p>\n"},
+		{func() { r.AddLinePrefix(NewTag("| ", 0, "pre", "raw")) }, "| *a*\n| b\n", "<pre class=\"raw\">*a*\nb\n</pre>\n"},
+		{func() { r.AddLinePrefix(NewTag(":: ", 1, "div", "")) }, ":: *a*\n:: b\n", "<div><em>a</em>\nb</div>\n"},
+		{func() { r.AddLinePrefix(NewTag("% ", 2, "aside", "")) }, "% # a\n% b\n", "<aside><h1>a</h1>\n<p>b</p>\n</aside>\n"},
+		{func() { r.RemoveLinePrefix(">") }, "> a\n", "<p>&gt; a</p>\n"},
+		{func() { r.RemoveLinePrefix("% ") }, "% a\n", "<p>% a</p>\n"},
+	}
+	for _, s := range steps {
+		s.change()
+		if got := string(r.Process([]byte(s.text))); got != s.want {
+			t.Errorf("%q:\ngot  %q\nwant %q", s.text, got, s.want)
+		}
+	}
+}
+
+// TestRenderers checks that renderers can run in parallel, run with -race,
+// and that the output of Process is not reused by a renderer.
+func TestRenderers(t *testing.T) {
+	text := []byte("# T\n\n- *a* [b](c)\n- d[^1]\n\n| x |\n|---|\n| y |\n\n[^1]: e\n")
+	want := New(Options{}).Process(text)
+
+	var wg sync.WaitGroup
+	outs := make([][]byte, 8)
+	for i := range outs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := New(Options{})
+			for range 10 {
+				outs[i] = r.Process(text)
+			}
+		}()
+	}
+	wg.Wait()
+	for i, out := range outs {
+		if !bytes.Equal(out, want) {
+			t.Errorf("renderer %d:\ngot  %q\nwant %q", i, out, want)
+		}
+	}
+
+	r := New(Options{})
+	first := r.Process([]byte("first *a*"))
+	saved := bytes.Clone(first)
+	second := r.Process([]byte("second *b*"))
+	if !bytes.Equal(first, saved) {
+		t.Errorf("second call changed the first output to %q", first)
+	}
+	clear(second)
+	if third := r.Process([]byte("x")); string(third) != "<p>x</p>\n" {
+		t.Errorf("output after changing the last one: %q", third)
+	}
+}
+
+/* failwriter fails every write after the first n */
+type failwriter struct {
+	n, writes int
+}
+
+var errWrite = errors.New("write failed")
+
+func (w *failwriter) Write(p []byte) (int, error) {
+	w.writes++
+	if w.writes > w.n {
+		return 0, errWrite
+	}
+	return len(p), nil
+}
+
+// TestRenderError checks that Render returns the first error of the writer
+// and stops writing then.
+func TestRenderError(t *testing.T) {
+	text := "# a\n\nb\n\nc\n"
+	for _, cm := range []bool{false, true} {
+		r := New(Options{CommonMark: cm})
+		ok := &failwriter{n: 100}
+		if err := r.Render(ok, strings.NewReader(text)); err != nil {
+			t.Fatalf("CommonMark %v: Render = %v", cm, err)
+		}
+		for n := range ok.writes {
+			w := &failwriter{n: n}
+			if err := r.Render(w, strings.NewReader(text)); err != errWrite {
+				t.Errorf("CommonMark %v, failing after %d writes: Render = %v, want %v", cm, n, err, errWrite)
+			}
+			if w.writes != n+1 {
+				t.Errorf("CommonMark %v: %d writes after the error, want 0", cm, w.writes-n-1)
+			}
+		}
+		if err := r.Render(&failwriter{n: 100}, strings.NewReader(text)); err != nil {
+			t.Errorf("CommonMark %v: Render = %v after an error", cm, err)
+		}
+	}
+}
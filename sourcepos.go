@@ -0,0 +1,245 @@
+package smu
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// SourcePos is the range of source lines and columns, both starting at 1,
+// a block was parsed from. Columns count bytes. It is only set if
+// Options.SourcePos is.
+type SourcePos struct {
+	StartLine, StartCol int
+	EndLine, EndCol     int
+}
+
+// String returns p as "line:col-line:col".
+func (p SourcePos) String() string {
+	return fmt.Sprintf("%d:%d-%d:%d", p.StartLine, p.StartCol, p.EndLine, p.EndCol)
+}
+
+/* srcmap maps positions in the text being parsed to the source. Text is
+ * either a part of the text of parent starting at off, or a copy of parts
+ * of it. */
+type srcmap struct {
+	text   []byte
+	parent *srcmap
+	off    int
+
+	starts []int /* start of every line of the document or part of a copy */
+	from   []int /* and where that part of a copy starts in parent */
+
+	pos   int     /* position of the running parser */
+	added []*Node /* blocks added at pos */
+}
+
+/* newsrcmap returns the map of the document text */
+func newsrcmap(text []byte) *srcmap {
+	m := &srcmap{text: text}
+	for p := 0; p < len(text); p = lineend(text, p) {
+		m.starts = append(m.starts, p)
+	}
+	return m
+}
+
+/* position returns the source line and column of offset o of the text */
+func (m *srcmap) position(o int) (int, int) {
+	if m.starts == nil && m.parent != nil {
+		return m.parent.position(m.off + o)
+	}
+	i := max(sort.SearchInts(m.starts, o+1)-1, 0)
+	if m.parent == nil {
+		if len(m.starts) == 0 {
+			return 1, o + 1
+		}
+		return i + 1, o - m.starts[i] + 1
+	}
+	return m.parent.position(m.from[i] + o - m.starts[i])
+}
+
+/* offset returns the offset of text in the text of m, or -1 if text is
+ * not a part of it */
+func (m *srcmap) offset(text []byte) int {
+	off := cap(m.text) - cap(text)
+	if len(text) > 0 && off >= 0 && off < len(m.text) && &m.text[off] == &text[0] {
+		return off
+	}
+	return -1
+}
+
+/* skipnl returns the first position from p that is not a newline */
+func (m *srcmap) skipnl(p int) int {
+	for p < len(m.text)-1 && m.text[p] == '\n' {
+		p++
+	}
+	return p
+}
+
+/* srccopy is a copy of parts of the text being parsed, such as the lines
+ * of a blockquote without their "> ", that knows where they came from */
+type srccopy struct {
+	buf   []byte
+	at    []int    /* start of every part in buf */
+	parts [][]byte /* and the text it starts with */
+}
+
+/* add appends part, a part of the text being parsed */
+func (c *srccopy) add(part []byte) {
+	c.at = append(c.at, len(c.buf))
+	c.parts = append(c.parts, part)
+	c.buf = append(c.buf, part...)
+}
+
+/* addbyte appends text[p]. Every line is a part. */
+func (c *srccopy) addbyte(text []byte, p int) {
+	if len(c.buf) == 0 || c.buf[len(c.buf)-1] == '\n' {
+		c.at = append(c.at, len(c.buf))
+		c.parts = append(c.parts, text[p:])
+	}
+	c.buf = append(c.buf, text[p])
+}
+
+/* cut returns the copy without its first i bytes */
+func (c srccopy) cut(i int) srccopy {
+	d := srccopy{buf: c.buf[i:]}
+	for k := max(sort.SearchInts(c.at, i+1)-1, 0); k < len(c.at); k++ {
+		part := c.parts[k]
+		if c.at[k] < i {
+			part = part[min(i-c.at[k], len(part)):]
+		}
+		d.at = append(d.at, max(c.at[k]-i, 0))
+		d.parts = append(d.parts, part)
+	}
+	return d
+}
+
+/* parsecopy parses the copy c into the children of n like ParseInto */
+func (r *Renderer) parsecopy(n *Node, c srccopy, newblock bool) {
+	if r.src != nil {
+		r.copy = &c
+	}
+	r.ParseInto(n, c.buf, newblock)
+}
+
+/* submap returns the map of text, which is parsed by the parser running at
+ * the current position */
+func (r *Renderer) submap(text []byte) *srcmap {
+	m := r.src
+	if off := m.offset(text); off != -1 {
+		return &srcmap{text: text, parent: m, off: off}
+	}
+
+	sub := &srcmap{text: text, parent: m, starts: []int{0}, from: []int{m.pos}}
+	if c := r.copy; c != nil && len(c.at) > 0 && len(text) > 0 && &c.buf[0] == &text[0] {
+		sub.starts, sub.from = sub.starts[:0], sub.from[:0]
+		for i, part := range c.parts {
+			off := m.offset(part)
+			if off == -1 {
+				off = m.pos
+			}
+			sub.starts = append(sub.starts, c.at[i])
+			sub.from = append(sub.from, off)
+		}
+	}
+	/* Other copies are taken to be the text at the parser's position */
+	return sub
+}
+
+/* span returns the range of text from start up to end, without newlines
+ * at either end */
+func (m *srcmap) span(start, end int) SourcePos {
+	start = m.skipnl(start)
+	end = min(end, len(m.text)) - 1
+	for end > start && m.text[end] == '\n' {
+		end--
+	}
+	var p SourcePos
+	p.StartLine, p.StartCol = m.position(start)
+	p.EndLine, p.EndCol = m.position(max(start, end))
+	return p
+}
+
+/* endblocks sets the end of the blocks added by the parser that ran at the
+ * current position and consumed text up to end */
+func (r *Renderer) endblocks(end int) {
+	for _, n := range r.src.added {
+		start := n.Pos
+		n.Pos = r.src.span(r.src.pos, end)
+		n.Pos.StartLine, n.Pos.StartCol = start.StartLine, start.StartCol
+	}
+	r.src.added = r.src.added[:0]
+}
+
+/* isblock reports whether nodes of type t are blocks with a position */
+func isblock(t NodeType) bool {
+	switch t {
+	case Paragraph, Heading, List, ListItem, DefinitionList, DefinitionTerm,
+		Definition, CodeBlock, MathBlock, Blockquote, Admonition, Table,
+		TableRow, TableCell, HorizontalRule, HTMLBlock, Footnote, CustomBlock:
+		return true
+	}
+	return false
+}
+
+/* fixsourcepos gives blocks without a position the range of their
+ * children and ends blocks before the next one. Paragraphs, for example,
+ * are parsed with any heading or list that interrupts them. */
+func fixsourcepos(doc *Node, lines [][]byte) {
+	Walk(doc, func(n *Node, entering bool) WalkStatus {
+		if entering {
+			return GoToNext
+		}
+		var prev *Node
+		for _, c := range n.Children {
+			if c.Pos.StartLine == 0 {
+				continue
+			}
+			if prev != nil && before(c.Pos.StartLine, c.Pos.StartCol, prev.Pos.EndLine, prev.Pos.EndCol) {
+				endbefore(prev, c, lines)
+			}
+			prev = c
+		}
+		if !isblock(n.Type) || n.Pos.StartLine != 0 {
+			return GoToNext
+		}
+		for _, c := range n.Children {
+			if c.Pos.StartLine == 0 {
+				continue
+			}
+			if n.Pos.StartLine == 0 {
+				n.Pos = c.Pos
+			}
+			n.Pos.EndLine, n.Pos.EndCol = c.Pos.EndLine, c.Pos.EndCol
+		}
+		return GoToNext
+	})
+}
+
+/* before reports whether line l1, column c1 comes before l2, c2 */
+func before(l1, c1, l2, c2 int) bool {
+	return l1 < l2 || l1 == l2 && c1 < c2
+}
+
+/* endbefore ends block n at the last line with text before next */
+func endbefore(n, next *Node, lines [][]byte) {
+	if next.Pos.StartLine <= n.Pos.StartLine {
+		n.Pos.EndLine = n.Pos.StartLine
+		n.Pos.EndCol = max(n.Pos.StartCol, next.Pos.StartCol-1)
+		return
+	}
+	l := next.Pos.StartLine - 1
+	for l > n.Pos.StartLine && len(bytes.TrimSpace(lines[l-1])) == 0 {
+		l--
+	}
+	n.Pos.EndLine, n.Pos.EndCol = l, max(1, len(lines[l-1]))
+}
+
+/* sourcepos writes the data-sourcepos attribute of block n */
+func (r *Renderer) sourcepos(n *Node) {
+	if r.opts.SourcePos && n.Pos.StartLine != 0 {
+		r.out.WriteString(" data-sourcepos=\"")
+		r.out.WriteString(n.Pos.String())
+		r.out.WriteString("\"")
+	}
+}
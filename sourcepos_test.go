@@ -0,0 +1,89 @@
+package smu
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+/* positions lists the blocks of doc with their source positions */
+func positions(doc *Node) string {
+	var b strings.Builder
+	Walk(doc, func(n *Node, entering bool) WalkStatus {
+		if entering && isblock(n.Type) {
+			fmt.Fprintf(&b, "%v %v; ", n.Type, n.Pos)
+		}
+		return GoToNext
+	})
+	return b.String()
+}
+
+// TestSourcePos checks the positions of blocks in both dialects. cm is the
+// result with Options.CommonMark if it differs.
+func TestSourcePos(t *testing.T) {
+	tests := []struct{ text, want, cm string }{
+		{"para one\nline two\n\n# H\n", "Paragraph 1:1-2:8; Heading 4:1-4:3; ", ""},
+		{"H\n===\n\ntext *em*\n", "Heading 1:1-2:3; Paragraph 4:1-4:9; ", ""},
+		{"- a\n- b\n  more\n\n  para\n- c\n",
+			"List 1:1-6:3; ListItem 1:1-1:3; ListItem 2:1-5:6; Paragraph 2:3-3:6; Paragraph 5:3-5:6; ListItem 6:1-6:3; Paragraph 6:3-6:3; ",
+			"List 1:1-6:3; ListItem 1:1-1:3; Paragraph 1:3-1:3; ListItem 2:1-5:6; Paragraph 2:3-3:6; Paragraph 5:3-5:6; ListItem 6:1-6:3; Paragraph 6:3-6:3; "},
+		{"text\n- a\n- b\n", "Paragraph 1:1-1:4; List 2:1-3:3; ListItem 2:1-2:3; ListItem 3:1-3:3; ",
+			"Paragraph 1:1-1:4; List 2:1-3:3; ListItem 2:1-2:3; Paragraph 2:3-2:3; ListItem 3:1-3:3; Paragraph 3:3-3:3; "},
+		{"- a\n  - b\n  - c\n", "List 1:1-3:5; ListItem 1:1-3:5; List 2:3-3:5; ListItem 2:3-2:5; ListItem 3:3-3:5; ",
+			"List 1:1-3:5; ListItem 1:1-3:5; Paragraph 1:3-1:3; List 2:3-3:5; ListItem 2:3-2:5; Paragraph 2:5-2:5; ListItem 3:3-3:5; Paragraph 3:5-3:5; "},
+		{"10. a\n\n    b\n11. c\n",
+			"List 1:1-4:5; ListItem 1:1-3:5; Paragraph 1:5-1:5; Paragraph 3:5-3:5; ListItem 4:1-4:5; Paragraph 4:5-4:5; ", ""},
+		{"> q1\n> q2\n>\n> - x\n", "Blockquote 1:1-4:5; Paragraph 1:3-2:4; List 4:3-4:5; ListItem 4:3-4:5; ",
+			"Blockquote 1:1-4:5; Paragraph 1:3-2:4; List 4:3-4:5; ListItem 4:3-4:5; Paragraph 4:5-4:5; "},
+		{"```go\ncode\n```\n\n    ind\n    ent\n", "CodeBlock 1:1-3:3; CodeBlock 5:1-6:7; ",
+			"CodeBlock 1:1-3:3; CodeBlock 5:5-6:7; "},
+		{"  indented para\n  two\n", "Paragraph 1:1-2:5; ", "Paragraph 1:3-2:5; "},
+		{"x\n\n<div>\nhtml\n</div>\n", "Paragraph 1:1-1:1; Paragraph 3:1-5:6; ",
+			"Paragraph 1:1-1:1; HTMLBlock 3:1-5:6; "},
+		{"Term\n: def\n\n---\n\n| a | b |\n|---|---|\n| 1 | 2 |\n",
+			"DefinitionList 1:1-2:5; DefinitionTerm 1:1-1:4; Definition 2:3-2:5; HorizontalRule 4:1-4:3; Table 6:1-8:9; TableRow 6:3-6:7; TableCell 6:3-6:3; TableCell 6:7-6:7; TableRow 8:3-8:7; TableCell 8:3-8:3; TableCell 8:7-8:7; ",
+			"Paragraph 1:1-2:5; HorizontalRule 4:1-4:3; Paragraph 6:1-8:9; "},
+	}
+	for _, tt := range tests {
+		if got := positions(New(Options{SourcePos: true}).Parse([]byte(tt.text))); got != tt.want {
+			t.Errorf("%q:\ngot  %s\nwant %s", tt.text, got, tt.want)
+		}
+		want := tt.cm
+		if want == "" {
+			want = tt.want
+		}
+		if got := positions(New(Options{SourcePos: true, CommonMark: true}).Parse([]byte(tt.text))); got != want {
+			t.Errorf("CommonMark %q:\ngot  %s\nwant %s", tt.text, got, want)
+		}
+	}
+
+	/* Repeated lines inside nested blocks get the positions they were
+	 * copied from */
+	repeated := []struct{ text, want string }{
+		{"Term\n: x\n\n  x\n\nx\n: x",
+			"DefinitionList 1:1-7:3; DefinitionTerm 1:1-1:4; Definition 2:3-4:3; Paragraph 2:3-2:3; Paragraph 4:3-4:3; DefinitionTerm 6:1-6:1; Definition 7:3-7:3; "},
+		{"> x\n> > x\n> x\n>\n> x\n",
+			"Blockquote 1:1-5:3; Paragraph 1:3-1:3; Blockquote 2:3-2:5; Paragraph 2:5-2:5; Paragraph 3:3-3:3; Paragraph 5:3-5:3; "},
+		{"- x\n\n  > x\n- x\n",
+			"List 1:1-4:3; ListItem 1:1-3:5; Paragraph 1:3-1:3; Blockquote 3:3-3:5; Paragraph 3:5-3:5; ListItem 4:1-4:3; Paragraph 4:3-4:3; "},
+		{"x[^1]\n\n[^1]: x\n\n    x\n",
+			"Paragraph 1:1-1:5; Footnote 3:7-5:5; Paragraph 3:7-3:7; Paragraph 5:5-5:5; "},
+		{"| x \\| x | x |\n|---|---|\n| x | x \\| x |\n",
+			"Table 1:1-3:14; TableRow 1:3-1:12; TableCell 1:3-1:8; TableCell 1:12-1:12; TableRow 3:3-3:12; TableCell 3:3-3:3; TableCell 3:7-3:12; "},
+	}
+	for _, tt := range repeated {
+		if got := positions(New(Options{SourcePos: true}).Parse([]byte(tt.text))); got != tt.want {
+			t.Errorf("%q:\ngot  %s\nwant %s", tt.text, got, tt.want)
+		}
+	}
+
+	/* Positions are only recorded and written if asked for */
+	text := []byte("> a\n> b\n")
+	if got := positions(New(Options{}).Parse(text)); got != "Blockquote 0:0-0:0; Paragraph 0:0-0:0; " {
+		t.Errorf("without SourcePos: %s", got)
+	}
+	want := "<blockquote data-sourcepos=\"1:1-2:3\"><p data-sourcepos=\"1:3-2:3\">a\nb</p>\n</blockquote>\n"
+	if got := string(New(Options{SourcePos: true}).Process(text)); got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+}
@@ -0,0 +1,49 @@
+package smu
+
+import "strings"
+
+// Task is a task list item, a list item starting with "[ ]" or "[x]".
+type Task struct {
+	Line    int // line of the item, starting at 1
+	Checked bool
+	Text    string // plain text of the item without the checkbox
+}
+
+// Tasks returns the task list items of text with the default options,
+// see Renderer.Tasks.
+func Tasks(text []byte) []Task {
+	return New(Options{}).Tasks(text)
+}
+
+// Tasks returns the task list items of text in document order, parsed
+// with the options and parsers of r.
+func (r *Renderer) Tasks(text []byte) []Task {
+	/* The lines of the items are their source positions */
+	opts := r.opts
+	r.opts.SourcePos = true
+	defer func() { r.opts = opts }()
+
+	var tasks []Task
+	Walk(r.Parse(text), func(n *Node, entering bool) WalkStatus {
+		if entering && n.Type == ListItem && n.Task {
+			tasks = append(tasks, Task{Line: n.Pos.StartLine, Checked: n.Checked, Text: itemtext(n)})
+		}
+		return GoToNext
+	})
+	return tasks
+}
+
+/* itemtext returns the plain text of a list item without nested lists.
+ * The blocks of loose items are separated by a space. */
+func itemtext(item *Node) string {
+	var text []string
+	for _, c := range item.Children {
+		if c.Type == List {
+			continue
+		}
+		if t := strings.TrimSpace(string(plaintext(c))); t != "" {
+			text = append(text, t)
+		}
+	}
+	return strings.Join(text, " ")
+}
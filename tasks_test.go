@@ -0,0 +1,47 @@
+package smu
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTasks(t *testing.T) {
+	tests := []struct{ text, want string }{
+		{"- [ ] a\n- [x] b\n- c\n", "[{1 false a} {2 true b}]"},
+		{"    - [ ] in code\n- [x] real\n", "[{2 true real}]"},
+		{"<!--\n- [ ] hidden\n-->\n- [x] real\n", "[{4 true real}]"},
+		{"```\n- [ ] fenced\n```\n\n1. [X] real\n", "[{5 true real}]"},
+		{"> - [ ] quoted\n\n- a\n  - [ ] *nested*\n", "[{1 false quoted} {4 false nested}]"},
+		{"> - [ ] x\n> - [ ] x\n>\n> > - [ ] x\n", "[{1 false x} {2 false x} {4 false x}]"},
+		{"- [ ] a\n- [x] same\n\n  para\n", "[{1 false a} {2 true same para}]"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(Tasks([]byte(tt.text))); got != tt.want {
+			t.Errorf("%q: got %s, want %s", tt.text, got, tt.want)
+		}
+	}
+
+	/* The paragraphs of loose items start after the checkbox */
+	out := New(Options{}).Process([]byte("- [ ] a\n- [x] same\n\n  para\n"))
+	want := "<ul>\n<li class=\"task-list-item\"><input type=\"checkbox\" disabled /> a</li>\n" +
+		"<li class=\"task-list-item\"><input type=\"checkbox\" checked disabled /><p>same</p>\n<p>para</p>\n</li>\n</ul>\n"
+	if string(out) != want {
+		t.Errorf("got  %q\nwant %q", out, want)
+	}
+}
+
+// TestTasksOptions checks that Renderer.Tasks parses with the options of
+// the renderer and leaves them as they were.
+func TestTasksOptions(t *testing.T) {
+	text := []byte("- [ ] ~~a~~\n")
+	r := New(Options{Strikethrough: true})
+	if got, want := fmt.Sprint(r.Tasks(text)), "[{1 false a}]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if got, want := fmt.Sprint(Tasks(text)), "[{1 false ~~a~~}]"; got != want {
+		t.Errorf("default options: got %s, want %s", got, want)
+	}
+	if r.opts.SourcePos {
+		t.Error("Tasks left SourcePos set")
+	}
+}
@@ -0,0 +1,111 @@
+package smu
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// TOCEntry is a heading in a table of contents.
+type TOCEntry struct {
+	Level    int
+	ID       string
+	Text     string
+	Children []*TOCEntry
+}
+
+// TOC returns the headings of doc as a table of contents. Each heading is
+// nested below the closest preceding heading of a lower level. Headings
+// without ids are given them as with Options.HeadingIDs.
+func TOC(doc *Node) []*TOCEntry {
+	var toc []*TOCEntry
+	var stack []*TOCEntry
+	Walk(doc, func(n *Node, entering bool) WalkStatus {
+		if !entering || n.Type != Heading {
+			return GoToNext
+		}
+		if n.ID == "" {
+			headingids(doc)
+		}
+		e := &TOCEntry{Level: n.Level, ID: n.ID, Text: string(plaintext(n))}
+		for len(stack) > 0 && stack[len(stack)-1].Level >= e.Level {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			toc = append(toc, e)
+		} else {
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, e)
+		}
+		stack = append(stack, e)
+		return SkipChildren
+	})
+	return toc
+}
+
+// RenderTOC writes toc as nested lists of links to the headings.
+func (r *Renderer) RenderTOC(w io.Writer, toc []*TOCEntry) error {
+	if len(toc) == 0 {
+		return nil
+	}
+	return r.RenderNode(w, tocList(toc))
+}
+
+func tocList(toc []*TOCEntry) *Node {
+	list := NewNode(List)
+	for _, e := range toc {
+		item := NewNode(ListItem)
+		link := NewNode(Link)
+		link.Dest = "#" + e.ID
+		text := NewNode(Text)
+		text.Literal = []byte(e.Text)
+		link.AppendChild(text)
+		item.AppendChild(link)
+		if len(e.Children) > 0 {
+			item.AppendChild(tocList(e.Children))
+		}
+		list.AppendChild(item)
+	}
+	return list
+}
+
+/* headingids gives every heading of doc a unique id derived from its text.
+ * Repeated ids are numbered, counting on from the last number of their
+ * base, as the lower numbers are all taken. */
+func headingids(doc *Node) {
+	seen := map[string]bool{}
+	next := map[string]int{}
+	Walk(doc, func(n *Node, entering bool) WalkStatus {
+		if !entering || n.Type != Heading {
+			return GoToNext
+		}
+		base := slugify(string(plaintext(n)))
+		if base == "" {
+			base = "section"
+		}
+		id := base
+		for seen[id] {
+			next[base]++
+			id = base + "-" + strconv.Itoa(next[base])
+		}
+		seen[id] = true
+		n.ID = id
+		return SkipChildren
+	})
+}
+
+/* slugify lower cases text, turns spaces into dashes and drops everything
+ * but letters, digits, dashes and underscores */
+func slugify(text string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(strings.TrimSpace(text)) {
+		switch {
+		case unicode.IsLetter(c), unicode.IsNumber(c), unicode.IsMark(c), c == '-', c == '_':
+			b.WriteRune(c)
+		case unicode.IsSpace(c):
+			b.WriteByte('-')
+		}
+	}
+	return b.String()
+}
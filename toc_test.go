@@ -0,0 +1,57 @@
+package smu
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+/* ids returns the ids of the headings of doc */
+func ids(doc *Node) []string {
+	var ids []string
+	Walk(doc, func(n *Node, entering bool) WalkStatus {
+		if entering && n.Type == Heading {
+			ids = append(ids, n.ID)
+		}
+		return GoToNext
+	})
+	return ids
+}
+
+func TestHeadingIDs(t *testing.T) {
+	text := []byte("# a\n\n# a\n\n# a-1\n\n# a\n\n# !\n\n# Ünï Cödé\n")
+	want := "[a a-1 a-1-1 a-2 section ünï-cödé]"
+	for _, opts := range []Options{{HeadingIDs: true}, {HeadingAnchors: true}, {HeadingIDs: true, CommonMark: true}} {
+		if got := fmt.Sprint(ids(New(opts).Parse(text))); got != want {
+			t.Errorf("%+v: ids %s, want %s", opts, got, want)
+		}
+	}
+
+	/* Ids are only given if they are written */
+	if got := fmt.Sprint(ids(New(Options{}).Parse(text))); got != "[     ]" {
+		t.Errorf("ids %s without HeadingIDs", got)
+	}
+}
+
+func TestTOC(t *testing.T) {
+	doc := New(Options{}).Parse([]byte("# a\n\n## b\n\n## b\n\n# c\n"))
+	toc := TOC(doc)
+	if len(toc) != 2 || len(toc[0].Children) != 2 {
+		t.Fatalf("toc %v, want 2 entries, the first with 2 children", toc)
+	}
+	got := []string{toc[0].ID, toc[0].Children[0].ID, toc[0].Children[1].ID, toc[1].ID}
+	if want := "[a b b-1 c]"; fmt.Sprint(got) != want {
+		t.Errorf("ids %v, want %s", got, want)
+	}
+}
+
+// TestHeadingIDsRepeated checks that many headings with the same text do
+// not take quadratic time.
+func TestHeadingIDsRepeated(t *testing.T) {
+	n := 20000
+	doc := New(Options{HeadingIDs: true}).Parse([]byte(strings.Repeat("# a\n\n", n)))
+	got := ids(doc)
+	if len(got) != n || got[n-1] != fmt.Sprintf("a-%d", n-1) {
+		t.Errorf("%d ids, the last %q", len(got), got[len(got)-1])
+	}
+}